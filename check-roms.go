@@ -3,50 +3,104 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
-	"github.com/antchfx/xmlquery"
 	flags "github.com/jessevdk/go-flags"
 )
 
 type options struct {
-	Datfile string `short:"d" long:"datfile" description:"dat file to use as reference database"`
-	Level   string `short:"l" long:"level" description:"level for information to show" choice:"error" choice:"warn" choice:"info" choice:"debug" default:"error"`
+	CacheDir string   `long:"cache-dir" description:"directory for compiled dat files (default: user cache directory)"`
+	Datfile  []string `short:"d" long:"datfile" description:"dat file, or directory of dat files, to use as reference database (can be specified multiple times)"`
+	Level    string   `short:"l" long:"level" description:"level for information to show" choice:"error" choice:"warn" choice:"info" choice:"debug" default:"error"`
+	NoCache  bool     `long:"no-cache" description:"always parse dat files rather than using compiled dat files"`
 }
 
 var opts options
-var datfile *xmlquery.Node
+var dats []*datFile
 
 var parser = flags.NewParser(&opts, flags.Default)
 
+//standaloneCommand is implemented by commands that load their own dat files rather than using
+//the datfile option
+type standaloneCommand interface {
+	standalone()
+}
+
+//slimDatCommand is implemented by commands that only need the sets and roms of the dat files, so
+//that large xml dats can be loaded without keeping the rest of the document in memory
+type slimDatCommand interface {
+	slimDats()
+}
+
 func main() {
 	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
 		if cmd != nil {
 			setOutputLevel()
 
-			datfile = checkDatFileAndOpen()
+			if _, ok := cmd.(standaloneCommand); !ok {
+				_, slim := cmd.(slimDatCommand)
+				var err error
+				dats, err = checkDatFilesAndOpen(slim)
+				if err != nil {
+					return err
+				}
+			}
 			return cmd.Execute(args)
 		}
 		return nil
 	}
 	_, err := parser.Parse()
-	if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+	if flagsErr, ok := err.(*flags.Error); err == nil || (ok && flagsErr.Type == flags.ErrHelp) {
 		os.Exit(0)
 	} else {
 		os.Exit(1)
 	}
 }
 
-func checkDatFileAndOpen() *xmlquery.Node {
-	if opts.Datfile == "" {
-		opts.Datfile = readFirstLine("./.dat")
+func checkDatFilesAndOpen(slim bool) ([]*datFile, error) {
+	var refs []datRef
+	for _, datPath := range opts.Datfile {
+		refs = append(refs, datRef{datPath, ""})
+	}
+	if len(refs) == 0 {
+		var err error
+		refs, err = findDatRefs(".")
+		errorExit(err)
 	}
 
-	if opts.Datfile == "" {
+	if len(refs) == 0 {
 		fmt.Println("the required flag `-d, --datfile` was not specified")
 		os.Exit(1)
 	}
 
-	return parseDatFile(opts.Datfile)
+	var loaded []*datFile
+	for _, ref := range refs {
+		for _, datPath := range datFilesAtPath(ref.Path) {
+			dat := loadDatFile(datPath, slim)
+			dat.Scope = ref.Scope
+			loaded = append(loaded, dat)
+		}
+	}
+	if len(loaded) == 0 {
+		var paths []string
+		for _, ref := range refs {
+			paths = append(paths, ref.Path)
+		}
+		return nil, fmt.Errorf("no dat files found in %s", strings.Join(paths, ", "))
+	}
+	return loaded, nil
+}
+
+//loadDatFile parses and indexes a single dat file, using the compiled dat cache when it is up to date
+func loadDatFile(datPath string, slim bool) *datFile {
+	message(levelInfo, "Loading dat %s", datPath)
+	doc, index := loadCachedDat(datPath, slim)
+	if doc == nil {
+		doc = parseDatFile(datPath, slim)
+		index = indexDatFile(doc)
+		saveCachedDat(datPath, slim, doc, index)
+	}
+	return &datFile{Path: datPath, Doc: doc, Index: index}
 }
 
 func setOutputLevel() {
@@ -60,3 +114,8 @@ func setOutputLevel() {
 		outputLevel = levelDebug
 	}
 }
+
+//setOptionChoices sets the allowed values of a command option from a list known only at runtime
+func setOptionChoices(cmd *flags.Command, longName string, choices []string) {
+	cmd.FindOptionByLongName(longName).Choices = choices
+}
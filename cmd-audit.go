@@ -4,8 +4,12 @@ import "time"
 
 type auditCommand struct {
 	Exclude     map[string]struct{} `short:"e" long:"exclude" description:"extension to exclude from file list (can be specified multiple times)"`
-	Method      string              `short:"m" long:"method" description:"method to use to match roms" choice:"sha1" choice:"md5" choice:"crc" default:"sha1"`
+	GroupBy     string              `long:"group-by" description:"group the sets report by a name tag"`
+	HeaderDir   string              `long:"header-dir" description:"directory containing clrmamepro header detector files (default: directory of the datfile)"`
+	Method      string              `short:"m" long:"method" description:"method to use to match roms" default:"sha1"`
 	Rename      bool                `short:"r" long:"rename" description:"rename unambiguous misnamed files (only loose files and zipped sets supported)"`
+	SetMode     string              `long:"set-mode" description:"how parent and clone sets are stored" choice:"non-merged" choice:"split" choice:"merged" default:"non-merged"`
+	Tags        []string            `short:"t" long:"tag" description:"only report sets with a name tag, as key=value or key!=value where key is region, language, revision, version, flag or dump (can be specified multiple times)"`
 	WorkerCount int                 `short:"w" long:"workers" description:"number of concurrent workers to use" default:"10"`
 	Positional  struct {
 		OutputFile string `description:"audit file for output (default: audit_<timestamp>.txt)"`
@@ -14,15 +18,21 @@ type auditCommand struct {
 
 var auditCmd auditCommand
 
+func (x *auditCommand) slimDats() {}
+
 func (x *auditCommand) Execute(args []string) error {
 	checkCmd.AllSets = true
 	auditCmd.Exclude["txt"] = struct{}{}
 	checkCmd.Exclude = auditCmd.Exclude
+	checkCmd.GroupBy = auditCmd.GroupBy
+	checkCmd.HeaderDir = auditCmd.HeaderDir
 	checkCmd.Method = auditCmd.Method
 	checkCmd.Quiet = true
 	checkCmd.Rename = auditCmd.Rename
+	checkCmd.SetMode = auditCmd.SetMode
 	checkCmd.SortFiles = true
 	checkCmd.SortSets = true
+	checkCmd.Tags = auditCmd.Tags
 	checkCmd.WorkerCount = auditCmd.WorkerCount
 	checkCmd.ViewSets = "all"
 	checkCmd.Positional.Files = []string{}
@@ -35,8 +45,11 @@ func (x *auditCommand) Execute(args []string) error {
 }
 
 func init() {
-	parser.AddCommand("audit",
+	cmd, err := parser.AddCommand("audit",
 		"Audit files against datfile",
 		"This command will audit the files and create a log about found and missing from a datfile",
 		&auditCmd)
+	errorExit(err)
+	setOptionChoices(cmd, "method", hashMethodNames())
+	setOptionChoices(cmd, "group-by", tagKeys)
 }
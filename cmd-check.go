@@ -2,13 +2,16 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/antchfx/xmlquery"
 )
@@ -16,12 +19,16 @@ import (
 type checkCommand struct {
 	AllSets     bool                `short:"a" long:"allsets" description:"report all sets that are missing"`
 	Exclude     map[string]struct{} `short:"e" long:"exclude" description:"extension to exclude from file list (can be specified multiple times)"`
-	Method      string              `short:"m" long:"method" description:"method to use to match roms" choice:"sha1" choice:"md5" choice:"crc" default:"sha1"`
+	GroupBy     string              `long:"group-by" description:"group the sets report by a name tag"`
+	Method      string              `short:"m" long:"method" description:"method to use to match roms" default:"sha1"`
 	OutputFile  string              `short:"o" long:"output" description:"file for output"`
 	Quiet       bool                `short:"q" long:"quiet" description:"do not print rom information for matches"`
 	Rename      bool                `short:"r" long:"rename" description:"rename unambiguous misnamed files (only loose files and zipped sets supported)"`
+	HeaderDir   string              `long:"header-dir" description:"directory containing clrmamepro header detector files (default: directory of the datfile)"`
+	SetMode     string              `long:"set-mode" description:"how parent and clone sets are stored" choice:"non-merged" choice:"split" choice:"merged" default:"non-merged"`
 	SortFiles   bool                `short:"f" long:"sort-files" description:"sort files alphabetically rather than by raw order"`
 	SortSets    bool                `short:"s" long:"sort-sets" description:"sort sets alphabetically rather than by datfile order"`
+	Tags        []string            `short:"t" long:"tag" description:"only report sets with a name tag, as key=value or key!=value where key is region, language, revision, version, flag or dump (can be specified multiple times)"`
 	WorkerCount int                 `short:"w" long:"workers" description:"number of concurrent workers to use" default:"10"`
 	ViewSets    string              `short:"v" long:"view" description:"which items to view" choice:"all" choice:"complete" choice:"missing" choice:"partial" default:"all"`
 	Positional  struct {
@@ -31,7 +38,15 @@ type checkCommand struct {
 
 var checkCmd checkCommand
 
+//checkHashMethods are the hash methods that files are hashed with, which includes the method used
+//by entries that do not have the requested hash
+var checkHashMethods []string
+
+func (x *checkCommand) slimDats() {}
+
 type gameInfo struct {
+	Dat         *datFile
+	Game        *xmlquery.Node
 	GameName    string
 	AllRoms     NodeSet
 	MissingRoms NodeSet
@@ -54,16 +69,49 @@ func processFile(filePath string) nodeList {
 		return nil
 	}
 
+	if fileInfo.IsDir() {
+		return checkDirectory(filePath)
+	}
+
 	//skip anything that is not a regular file
 	if !fileInfo.Mode().IsRegular() {
 		message(levelWarn, "%s is not a regular file, skipping.", filePath)
 		return nil
 	}
 
-	if fileExt == "zip" {
+	switch fileExt {
+	case "zip":
 		return checkZip(filePath)
+	case "chd":
+		return checkDisk(fileInfo, filePath, "")
+	}
+	return checkFile(fileInfo, filePath, "")
+}
+
+//checkDirectory checks the files in a set directory, which is how disk images are usually stored
+func checkDirectory(dirPath string) nodeList {
+	dirName := filepath.Base(dirPath)
+	allMatches := make(nodeList, 0)
+	for _, filePath := range filesInDirectory(dirPath) {
+		fileExt := strings.TrimPrefix(filepath.Ext(filePath), ".")
+		if _, ok := checkCmd.Exclude[fileExt]; ok {
+			message(levelInfo, "%s has excluded extension, skipping.", filePath)
+			continue
+		}
+
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			message(levelError, "Cannot check %s, skipping. Reason: %s", filePath, err)
+			continue
+		}
+
+		if fileExt == "chd" {
+			allMatches = append(allMatches, checkDisk(fileInfo, filePath, dirName)...)
+		} else {
+			allMatches = append(allMatches, checkFile(fileInfo, filePath, dirName)...)
+		}
 	}
-	return checkFile(fileInfo, filePath)
+	return allMatches
 }
 
 func checkZip(zipFilePath string) nodeList {
@@ -75,6 +123,11 @@ func checkZip(zipFilePath string) nodeList {
 	}
 	defer reader.Close()
 
+	members := make(map[string]struct{}, len(reader.File))
+	for _, f := range reader.File {
+		members[f.Name] = struct{}{}
+	}
+
 	allMatches := make(nodeList, 0)
 	for _, f := range reader.File {
 		fileName := f.Name
@@ -96,7 +149,20 @@ func checkZip(zipFilePath string) nodeList {
 			continue
 		}
 		defer r.Close()
-		allMatches = append(allMatches, findRomMatches(fileInfo, r, zipFileName, false, fileName)...)
+		if isDiscSheet(fileName) && fileInfo.Size() <= maxDiscSheetSize {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				message(levelError, "%s could not be read : %s", fileName, err)
+				continue
+			}
+			allMatches = append(allMatches, checkDiscSheet(fileInfo, data, zipFileName, false, filepath.Join(zipFilePath, fileName),
+				func(track string) bool {
+					_, ok := members[path.Join(path.Dir(fileName), track)]
+					return ok
+				})...)
+			continue
+		}
+		allMatches = append(allMatches, findRomMatches(fileInfo, r, zipFileName, false, filepath.Join(zipFilePath, fileName))...)
 	}
 
 	if checkCmd.Rename {
@@ -123,28 +189,211 @@ func checkZip(zipFilePath string) nodeList {
 	return allMatches
 }
 
-func checkFile(fileInfo os.FileInfo, filePath string) nodeList {
+func checkFile(fileInfo os.FileInfo, filePath string, container string) nodeList {
 	f, err := os.Open(filePath)
 	if err != nil {
 		message(levelError, "%s could not be opened : %s", filePath, err)
 		return nil
 	}
 	defer f.Close()
-	return findRomMatches(fileInfo, f, "", checkCmd.Rename, filePath)
+	if isDiscSheet(filePath) && fileInfo.Size() <= maxDiscSheetSize {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			message(levelError, "%s could not be read : %s", filePath, err)
+			return nil
+		}
+		return checkDiscSheet(fileInfo, data, container, checkCmd.Rename, filePath,
+			func(track string) bool {
+				_, err := os.Stat(filepath.Join(filepath.Dir(filePath), filepath.FromSlash(track)))
+				return err == nil
+			})
+	}
+	return findRomMatches(fileInfo, f, container, checkCmd.Rename, filePath)
+}
+
+//checkDisk matches a chd disk image using the sha1 stored in its header
+func checkDisk(fileInfo os.FileInfo, filePath string, container string) nodeList {
+	fileHash, version, err := readChdSha1(filePath)
+	if err != nil {
+		message(levelError, "%s could not be read as a chd, skipping. Reason: %s", filePath, err)
+		return nil
+	}
+	message(levelDebug, "%s is a version %d chd with sha1 %s", filePath, version, fileHash)
+
+	diskName := strings.TrimSuffix(fileInfo.Name(), filepath.Ext(fileInfo.Name()))
+	return reportMatches(fileInfo, fileHash, "sha1", container, false, filePath,
+		func(dat *datFile) datMatch {
+			romList, matchType := matchDiskEntries(dat.Index, diskName, fileHash)
+			return datMatch{dat, romList, matchType, fileHash, nil, "", false}
+		})
+}
+
+//checkDiscSheet matches a cue or gdi sheet, accepting a sheet that was regenerated with only its
+//whitespace or line endings changed, then checks that each track it references is present and is
+//part of the sets that the sheet matched
+func checkDiscSheet(fileInfo os.FileInfo, data []byte, container string, rename bool, filePath string,
+	hasTrack func(track string) bool) nodeList {
+	fileName := fileInfo.Name()
+	fileHashes := hashFileAll(bytes.NewReader(data), checkHashMethods)
+	fileHash := fileHashes[checkCmd.Method]
+	matches := reportMatches(fileInfo, fileHash, checkCmd.Method, container, rename, filePath,
+		func(dat *datFile) datMatch {
+			romList, matchType, hash := matchEntries(dat.Index, fileName, fileHashes, checkCmd.Method)
+			if matchType != matchName {
+				return datMatch{dat, romList, matchType, hash, fileHashes, "", false}
+			}
+			for _, sheet := range regeneratedSheets(fileName, data) {
+				sheetHashes := hashFileAll(bytes.NewReader(sheet), checkHashMethods)
+				if sheetList, sheetType, sheetHash := matchEntries(dat.Index, fileName, sheetHashes, checkCmd.Method); sheetType == matchAll {
+					message(levelDebug, "%s matches %s after regenerating its whitespace and line endings", fileName, sheetHash)
+					return datMatch{dat, sheetList, sheetType, sheetHash, fileHashes, "", true}
+				}
+			}
+			return datMatch{dat, romList, matchType, hash, fileHashes, "", false}
+		})
+
+	var sets []*xmlquery.Node
+	seen := make(map[*xmlquery.Node]struct{})
+	for _, romNode := range matches {
+		if _, ok := seen[romNode.Parent]; !ok {
+			seen[romNode.Parent] = struct{}{}
+			sets = append(sets, romNode.Parent)
+		}
+	}
+	for _, track := range discSheetTracks(fileName, data) {
+		if !hasTrack(track) {
+			output("[MISS] %s %s - missing track, referenced by %s", track, container, fileName)
+			continue
+		}
+		for _, set := range sets {
+			if !hasRomNamed(set, track) {
+				output("[WARN] %s %s - referenced by %s but not part of set %s", track, container, fileName, findAttr(set, "name"))
+			}
+		}
+	}
+	return matches
+}
+
+//hasRomNamed returns true if the set has a rom with the name, which may use either path separator
+func hasRomNamed(set *xmlquery.Node, name string) bool {
+	for item := set.FirstChild; item != nil; item = item.NextSibling {
+		if item.Type == xmlquery.ElementNode && item.Data == "rom" && filepath.ToSlash(findAttr(item, "name")) == filepath.ToSlash(name) {
+			return true
+		}
+	}
+	return false
+}
+
+//datMatch holds the entries of a single dat that matched a file, along with the hash that matched,
+//the hashes of the file by method, the name of the header detector if the header was skipped to
+//match and whether the file only matched once its whitespace was regenerated
+type datMatch struct {
+	Dat         *datFile
+	Roms        nodeList
+	MatchType   match
+	Hash        string
+	Hashes      map[string]string
+	Header      string
+	Regenerated bool
+}
+
+//hashFor returns the file hash to show against an entry, using the hash method of the entry
+func (datMatch datMatch) hashFor(entry *xmlquery.Node, method string) string {
+	if hash, ok := datMatch.Hashes[entryHashMethod(entry, method)]; ok {
+		return hash
+	}
+	return datMatch.Hash
 }
 
 func findRomMatches(fileInfo os.FileInfo, reader io.Reader, container string, rename bool, filePath string) nodeList {
 	fileName := fileInfo.Name()
-	fileHash := hashFile(reader, checkCmd.Method)
+	if !hasHeaderDetectors() || fileInfo.Size() > maxHeaderedFileSize {
+		fileHashes := hashFileAll(reader, checkHashMethods)
+		return reportMatches(fileInfo, fileHashes[checkCmd.Method], checkCmd.Method, container, rename, filePath,
+			func(dat *datFile) datMatch {
+				romList, matchType, hash := matchEntries(dat.Index, fileName, fileHashes, checkCmd.Method)
+				return datMatch{dat, romList, matchType, hash, fileHashes, "", false}
+			})
+	}
 
-	romList, matchType := matchEntries(datfile, fileName, fileHash, checkCmd.Method)
-	if matchType == matchNone {
-		output("[MISS] %s %s %s - unknown, no match", fileHash, fileName, container)
-	} else {
-		for _, romNode := range romList {
+	//the file is read into memory so that it can be hashed both with and without its header
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		message(levelError, "%s could not be read : %s", filePath, err)
+		return nil
+	}
+	fileHashes := hashFileAll(bytes.NewReader(data), checkHashMethods)
+	return reportMatches(fileInfo, fileHashes[checkCmd.Method], checkCmd.Method, container, rename, filePath,
+		func(dat *datFile) datMatch {
+			romList, matchType, hash := matchEntries(dat.Index, fileName, fileHashes, checkCmd.Method)
+			rawMatch := datMatch{dat, romList, matchType, hash, fileHashes, "", false}
+			if matchType == matchAll || dat.Detector == nil {
+				return rawMatch
+			}
+
+			headerless, ok := dat.Detector.skipHeader(data)
+			if !ok {
+				return rawMatch
+			}
+			headerlessHashes := hashFileAll(bytes.NewReader(headerless), checkHashMethods)
+			message(levelDebug, "%s has header detected by %s, hash without header %s", fileName, dat.Detector.Name, headerlessHashes[checkCmd.Method])
+			romList, matchType, hash = matchEntries(dat.Index, fileName, headerlessHashes, checkCmd.Method)
+			if matchType > rawMatch.MatchType {
+				return datMatch{dat, romList, matchType, hash, headerlessHashes, dat.Detector.Name, false}
+			}
+			return rawMatch
+		})
+}
+
+//hasHeaderDetectors returns true if any of the dats needs headers to be skipped
+func hasHeaderDetectors() bool {
+	for _, dat := range dats {
+		if dat.Detector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+//reportMatches matches a file against every dat and prints the result
+func reportMatches(fileInfo os.FileInfo, fileHash string, method string, container string, rename bool, filePath string,
+	matcher func(dat *datFile) datMatch) nodeList {
+	fileName := fileInfo.Name()
+
+	var found []datMatch
+	for _, dat := range dats {
+		if !dat.appliesTo(filePath) {
+			continue
+		}
+		datMatch := matcher(dat)
+		if datMatch.MatchType != matchNone {
+			found = append(found, datMatch)
+		}
+	}
+
+	if len(found) == 0 {
+		printUnknown(fileHash, fileName, container)
+		return nil
+	}
+
+	matches := make(nodeList, 0)
+	for _, datMatch := range found {
+		matchType := datMatch.MatchType
+		if matchType == matchAll || matchType == matchHash {
+			placed, misplaced := placedRoms(datMatch.Dat, datMatch.Roms, container)
+			if len(placed) == 0 {
+				for _, romNode := range misplaced {
+					printMisplaced(datLabel(datMatch.Dat, container), fileInfo, datMatch.hashFor(romNode, method),
+						expectedContainer(datMatch.Dat.Index, romNode, checkCmd.SetMode))
+				}
+				continue
+			}
+			datMatch.Roms = placed
+		}
+		for _, romNode := range datMatch.Roms {
 			//if there is a single match just by hash, then rename if allowed
 			romAttr := mapAttr(romNode)
-			if rename && matchType == matchHash && len(romList) == 1 {
+			if rename && matchType == matchHash && len(found) == 1 && len(datMatch.Roms) == 1 {
 				romName := romAttr["name"]
 				ok := renameFile(filePath, romName)
 				if ok && !checkCmd.Quiet {
@@ -152,38 +401,122 @@ func findRomMatches(fileInfo os.FileInfo, reader io.Reader, container string, re
 					matchType = matchAll //it now matches all, so print as such
 				}
 			}
-			printMatch(container, fileInfo, fileHash, romAttr, matchType)
+			label := datLabel(datMatch.Dat, container)
+			if datMatch.Header != "" {
+				label = strings.TrimSpace(label + " (header skipped by " + datMatch.Header + ")")
+			}
+			romMethod := entryHashMethod(romNode, method)
+			if datMatch.Regenerated {
+				printRegenerated(label, fileInfo, datMatch.hashFor(romNode, method), romMethod, romAttr)
+			} else {
+				printMatch(label, fileInfo, datMatch.hashFor(romNode, method), romMethod, romAttr, matchType)
+			}
+		}
+		if matchType == matchAll || matchType == matchHash {
+			matches = append(matches, datMatch.Roms...)
 		}
 	}
-	if matchType == matchAll || matchType == matchHash {
-		return romList
+	return matches
+}
+
+//placedRoms splits the matched entries into those that the set mode expects to be stored in the
+//container and those that should be stored in another set. Loose files are not in a set and so
+//are never misplaced
+func placedRoms(dat *datFile, roms nodeList, container string) (nodeList, nodeList) {
+	if container == "" {
+		return roms, nil
+	}
+	setName := container
+	if strings.EqualFold(filepath.Ext(container), ".zip") {
+		setName = strings.TrimSuffix(container, filepath.Ext(container))
 	}
-	return nil
+
+	var placed, misplaced nodeList
+	for _, romNode := range roms {
+		if expectedContainer(dat.Index, romNode, checkCmd.SetMode) == setName {
+			placed = append(placed, romNode)
+		} else {
+			message(levelDebug, "Rom %s of %s is not stored in %s for %s sets",
+				findAttr(romNode, "name"), findAttr(romNode.Parent, "name"), container, checkCmd.SetMode)
+			misplaced = append(misplaced, romNode)
+		}
+	}
+	return placed, misplaced
+}
+
+//datLabel adds the name of the dat to the container when checking against multiple dats
+func datLabel(dat *datFile, container string) string {
+	if len(dats) < 2 {
+		return container
+	}
+	return strings.TrimSpace(container + " [" + dat.Name() + "]")
 }
 
-func printMatch(container string, fileInfo os.FileInfo, fileHash string, romAttr map[string]string, matchType match) {
+var unknownFiles []string
+var unknownFilesLock sync.Mutex
+
+//printUnknown reports a file that matched no dat, which is held back for its own section
+//when checking against multiple dats
+func printUnknown(fileHash string, fileName string, container string) {
+	if len(dats) < 2 {
+		output("[MISS] %s %s %s - unknown, no match", fileHash, fileName, container)
+		return
+	}
+	unknownFilesLock.Lock()
+	defer unknownFilesLock.Unlock()
+	unknownFiles = append(unknownFiles, fmt.Sprintf("[MISS] %s %s %s - unknown, no match", fileHash, fileName, container))
+}
+
+func printMatch(container string, fileInfo os.FileInfo, fileHash string, method string, romAttr map[string]string, matchType match) {
 	fileName := fileInfo.Name()
 	switch matchType {
 	case matchAll:
-		if !checkCmd.Quiet {
+		if romAttr["status"] == statusBadDump {
+			output("[BDMP] %s %s %s - matches known bad dump",
+				fileHash, fileName, container)
+		} else if !checkCmd.Quiet {
 			output("[ OK ] %s %s %s",
 				fileHash, fileName, container)
 		}
 	case matchHash:
-		if !checkCmd.Quiet {
+		if romAttr["status"] == statusBadDump {
+			output("[BDMP] %s %s %s - matches known bad dump, misnamed, should be %s",
+				fileHash, fileName, container,
+				romAttr["name"])
+		} else if !checkCmd.Quiet {
 			output("[WARN] %s %s %s - misnamed, should be %s",
 				fileHash, fileName, container,
 				romAttr["name"])
 		}
 	case matchName:
+		if romAttr["status"] == statusNoDump {
+			output("[NDMP] %s %s %s - unable to verify, no good dump is known",
+				fileHash, fileName, container)
+			return
+		}
 		output("[BAD ] %s %s %s - incorrect, expected %s %s",
 			fileHash, fileName, container,
-			strings.ToLower(romAttr[checkCmd.Method]),
+			strings.ToLower(romAttr[method]),
 			printSizeMismatch(fileInfo, romAttr["size"]))
 
 	}
 }
 
+//printMisplaced reports a file that matched only entries that the set mode stores in another set
+func printMisplaced(container string, fileInfo os.FileInfo, fileHash string, expected string) {
+	output("[WARN] %s %s %s - misplaced, should be in %s", fileHash, fileInfo.Name(), container, expected)
+}
+
+//printRegenerated reports a file whose content only matches once its whitespace and line endings
+//are regenerated, which happens when a cue sheet is saved by a different tool
+func printRegenerated(container string, fileInfo os.FileInfo, fileHash string, method string, romAttr map[string]string) {
+	if !checkCmd.Quiet {
+		output("[REGN] %s %s %s - regenerated, differs only in whitespace or line endings, expected %s",
+			fileHash, fileInfo.Name(), container,
+			strings.ToLower(romAttr[method]))
+	}
+}
+
 func printSizeMismatch(fileInfo os.FileInfo, sizeText string) string {
 	message := ""
 	fileSize := fileInfo.Size()
@@ -204,13 +537,13 @@ func updateGameMapFromGameNode(gameNode *xmlquery.Node, gameMap gameRomMap, game
 	info, ok := gameMap[gameNode]
 	if !ok {
 		gameName := findAttr(gameNode, "name")
-		allRoms := childNodeSet(gameNode, "rom")
+		allRoms := requiredRomSet(gameNode)
 		//delete is in-place so do not use same reference, copy instead
 		missingRoms := make(NodeSet)
 		for key, value := range allRoms {
 			missingRoms[key] = value
 		}
-		info = &gameInfo{gameName, allRoms, missingRoms}
+		info = &gameInfo{datForNode(gameNode), gameNode, gameName, allRoms, missingRoms}
 		gameMap[gameNode] = info
 		*gameList = append(*gameList, info)
 		message(levelInfo, "Adding game %s with %d roms...", findAttr(gameNode, "name"), len(allRoms))
@@ -223,12 +556,44 @@ func updateGameMapFromRomNode(romNode *xmlquery.Node, gameMap gameRomMap, gameLi
 	info := updateGameMapFromGameNode(gameNode, gameMap, gameList)
 	if _, ok := info.MissingRoms[romNode]; ok {
 		message(levelDebug, "Removing rom %s %s from %s...",
-			findAttr(romNode, checkCmd.Method), findAttr(romNode, "name"), findAttr(gameNode, "name"))
+			findAttr(romNode, entryHashMethod(romNode, checkCmd.Method)), findAttr(romNode, "name"), findAttr(gameNode, "name"))
 		delete(info.MissingRoms, romNode)
 		message(levelDebug, "Game %s now has %d missing roms", findAttr(gameNode, "name"), len(info.MissingRoms))
 	} else {
 		message(levelInfo, "Missing rom %s %s in %s, possible duplicate rom detected",
-			findAttr(romNode, checkCmd.Method), findAttr(romNode, "name"), findAttr(gameNode, "name"))
+			findAttr(romNode, entryHashMethod(romNode, checkCmd.Method)), findAttr(romNode, "name"), findAttr(gameNode, "name"))
+	}
+}
+
+//resolveMergedRoms marks roms that are missing from a set as found when the set mode stores them
+//in a parent set and they were found there, or when they are stored in a bios set
+func resolveMergedRoms(gameMap gameRomMap) {
+	if checkCmd.SetMode == setModeNonMerged {
+		return
+	}
+	for gameNode, info := range gameMap {
+		for romNode := range info.MissingRoms {
+			source := mergeSource(info.Dat.Index, romNode)
+			if source == romNode {
+				continue
+			}
+			if isBios(source.Parent) {
+				//bios roms are checked as a dependency of the set rather than as part of it
+				message(levelDebug, "Rom %s in %s is stored in bios %s",
+					findAttr(romNode, "name"), findAttr(gameNode, "name"), findAttr(source.Parent, "name"))
+				delete(info.MissingRoms, romNode)
+				continue
+			}
+			sourceInfo, ok := gameMap[source.Parent]
+			if !ok {
+				continue
+			}
+			if _, missing := sourceInfo.MissingRoms[source]; !missing {
+				message(levelDebug, "Rom %s in %s found merged in %s",
+					findAttr(romNode, "name"), findAttr(gameNode, "name"), sourceInfo.GameName)
+				delete(info.MissingRoms, romNode)
+			}
+		}
 	}
 }
 
@@ -241,6 +606,28 @@ func worker(id int, ic <-chan string, oc chan<- nodeList) {
 	message(levelDebug, "Worker %d Exiting", id)
 }
 
+//defaultCheckFiles returns the sets in the directory, or the sets in each directory that a dat
+//was mapped to when checking from the root of a collection
+func defaultCheckFiles(dirName string) []string {
+	var scopes []string
+	seen := make(map[string]struct{})
+	for _, dat := range dats {
+		if _, ok := seen[dat.Scope]; dat.Scope != "" && !ok {
+			seen[dat.Scope] = struct{}{}
+			scopes = append(scopes, dat.Scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return setsInDirectory(dirName)
+	}
+
+	var files []string
+	for _, scope := range scopes {
+		files = append(files, setsInDirectory(scope)...)
+	}
+	return files
+}
+
 func (x *checkCommand) Execute(args []string) error {
 	if checkCmd.OutputFile != "" {
 		f, err := os.Create(checkCmd.OutputFile)
@@ -250,19 +637,31 @@ func (x *checkCommand) Execute(args []string) error {
 		}
 		outputFile = f
 	}
+	tagFilters, err := parseTagFilters(checkCmd.Tags)
+	if err != nil {
+		message(levelError, "%s", err)
+		return err
+	}
+	for _, dat := range dats {
+		dat.Detector = findHeaderDetector(dat, checkCmd.HeaderDir)
+	}
+	checkHashMethods = datHashMethods(checkCmd.Method)
+
 	gameMap := make(gameRomMap)
 	gameList := make([]*gameInfo, 0)
 	if checkCmd.AllSets {
-		//add everything from the datfile to the gameRomMap
-		for _, game := range findGameEntries(datfile) {
-			updateGameMapFromGameNode(game, gameMap, &gameList)
+		//add everything from the datfiles to the gameRomMap
+		for _, dat := range dats {
+			for _, game := range findGameEntries(dat.Index) {
+				updateGameMapFromGameNode(game, gameMap, &gameList)
+			}
 		}
 	}
 
 	if len(checkCmd.Positional.Files) == 0 {
 		dirName, err := os.Getwd()
 		errorExit(err)
-		checkCmd.Positional.Files = filesInDirectory(dirName)
+		checkCmd.Positional.Files = defaultCheckFiles(dirName)
 	}
 	if checkCmd.SortFiles {
 		sort.Strings(checkCmd.Positional.Files)
@@ -300,20 +699,109 @@ func (x *checkCommand) Execute(args []string) error {
 	//close inputs and close workers
 	close(inputs)
 
-	output("--SETS--")
+	resolveMergedRoms(gameMap)
+
+	if len(dats) > 1 {
+		output("--UNKNOWN FILES--")
+		if checkCmd.SortFiles {
+			sort.Strings(unknownFiles)
+		}
+		for _, line := range unknownFiles {
+			output("%s", line)
+		}
+	}
+
 	if checkCmd.SortSets {
-		sort.Slice(gameList, func(i, j int) bool { return gameList[i].GameName < gameList[j].GameName })
+		sort.SliceStable(gameList, func(i, j int) bool { return gameList[i].GameName < gameList[j].GameName })
+	}
+
+	checker := newDependencyChecker(gameMap)
+	for _, dat := range dats {
+		var datGames []*gameInfo
+		for _, info := range gameList {
+			if info.Dat == dat && (len(tagFilters) == 0 || matchesTagFilters(gameTags(info.Game), tagFilters)) {
+				datGames = append(datGames, info)
+			}
+		}
+		var heading []string
+		if len(dats) > 1 {
+			heading = append(heading, dat.Name())
+		}
+		if checkCmd.GroupBy == "" {
+			printSetsHeading(heading)
+			printSets(datGames, checker)
+			continue
+		}
+		groupNames, groups := groupSetsByTag(datGames, checkCmd.GroupBy)
+		for _, groupName := range groupNames {
+			printSetsHeading(append(heading, checkCmd.GroupBy+"="+groupName))
+			printSets(groups[groupName], checker)
+		}
+	}
+
+	return nil
+}
+
+func printSetsHeading(heading []string) {
+	if len(heading) > 0 {
+		output("--SETS: %s--", strings.Join(heading, ", "))
+	} else {
+		output("--SETS--")
 	}
+}
+
+//groupSetsByTag splits the sets by the values of a kind of name tag, returning the sorted names of
+//the groups, where sets without the tag are grouped as none
+func groupSetsByTag(gameList []*gameInfo, key string) ([]string, map[string][]*gameInfo) {
+	groups := make(map[string][]*gameInfo)
+	var groupNames []string
+	for _, info := range gameList {
+		groupName := strings.Join(gameTags(info.Game).values(key), ", ")
+		if groupName == "" {
+			groupName = "none"
+		}
+		if _, ok := groups[groupName]; !ok {
+			groupNames = append(groupNames, groupName)
+		}
+		groups[groupName] = append(groups[groupName], info)
+	}
+	sort.Strings(groupNames)
+	return groupNames, groups
+}
 
+func printSets(gameList []*gameInfo, checker *dependencyChecker) {
 	completeSets := 0
+	badDumpSets := 0
 	missingSets := 0
 	partialSets := 0
+	playableSets := 0
+	blockedCounts := make(map[*xmlquery.Node]int)
 	for _, info := range gameList {
 		numMissing := len(info.MissingRoms)
 		if numMissing == 0 {
-			completeSets++
+			badDumps := hasBadDumps(info.AllRoms)
+			if badDumps {
+				badDumpSets++
+			} else {
+				completeSets++
+			}
+			blockers := checker.blockers(info.Dat.Index, info.Game)
+			if len(blockers) == 0 {
+				playableSets++
+			}
+			for blocker := range blockers {
+				blockedCounts[blocker]++
+			}
 			if checkCmd.ViewSets == "all" || checkCmd.ViewSets == "complete" {
-				output("[ OK ]  %s", info.GameName)
+				label := "[ OK ]"
+				if badDumps {
+					label = "[BDMP]"
+				}
+				if len(blockers) == 0 {
+					output("%s  %s", label, info.GameName)
+				} else {
+					output("%s  %s - unplayable, requires %s", label, info.GameName, strings.Join(nodeNames(blockers), ", "))
+				}
 			}
 		} else if len(info.AllRoms) == numMissing {
 			missingSets++
@@ -326,24 +814,49 @@ func (x *checkCommand) Execute(args []string) error {
 				output("[WARN]  %s is missing:", info.GameName)
 				for romNode := range info.MissingRoms {
 					romAttr := mapAttr(romNode)
-					romHash := strings.ToLower(romAttr[checkCmd.Method])
+					romHash := strings.ToLower(romAttr[entryHashMethod(romNode, checkCmd.Method)])
 					romName := romAttr["name"]
-					output("        %s %s", romHash, romName)
+					container := expectedContainer(info.Dat.Index, romNode, checkCmd.SetMode)
+					if container != info.GameName {
+						output("        %s %s (expected in %s)", romHash, romName, container)
+					} else {
+						output("        %s %s", romHash, romName)
+					}
 				}
 			}
 		}
 	}
 	output("--SET STATISTICS--")
 	output("\tComplete: %d", completeSets)
+	output("\tComplete with bad dumps: %d", badDumpSets)
 	output("\tPartial: %d", partialSets)
 	output("\tMissing: %d", missingSets)
+	output("\tPlayable: %d", playableSets)
 
-	return nil
+	if len(blockedCounts) > 0 {
+		blockingSets := make([]*xmlquery.Node, 0, len(blockedCounts))
+		for blocker := range blockedCounts {
+			blockingSets = append(blockingSets, blocker)
+		}
+		sort.Slice(blockingSets, func(i, j int) bool {
+			if blockedCounts[blockingSets[i]] != blockedCounts[blockingSets[j]] {
+				return blockedCounts[blockingSets[i]] > blockedCounts[blockingSets[j]]
+			}
+			return findAttr(blockingSets[i], "name") < findAttr(blockingSets[j], "name")
+		})
+		output("--BLOCKING SETS--")
+		for _, blocker := range blockingSets {
+			output("\t%s: %d", findAttr(blocker, "name"), blockedCounts[blocker])
+		}
+	}
 }
 
 func init() {
-	parser.AddCommand("check",
+	cmd, err := parser.AddCommand("check",
 		"Check files against datfile",
 		"This command will check files against a datfile and determine if all files for a game are present",
 		&checkCmd)
+	errorExit(err)
+	setOptionChoices(cmd, "method", hashMethodNames())
+	setOptionChoices(cmd, "group-by", tagKeys)
 }
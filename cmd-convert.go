@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+type convertCommand struct {
+	DropHashes []string `long:"drop-hash" description:"hash attribute to remove from each rom (can be specified multiple times)"`
+	DropUnused bool     `long:"drop-unused-hashes" description:"remove hash attributes and csv columns that no rom has a value for"`
+	Format     string   `short:"f" long:"format" description:"format to write the datfile in" choice:"logiqx" choice:"clrmamepro" choice:"csv" choice:"json" default:"logiqx"`
+	OutputFile string   `short:"o" long:"output" description:"file for output (default: stdout)"`
+	SortSets   bool     `short:"s" long:"sort-sets" description:"sort sets alphabetically rather than by datfile order"`
+}
+
+var convertCmd convertCommand
+
+//sortSetEntries reorders the sets of the document by name, leaving the header first
+func sortSetEntries(doc *xmlquery.Node) {
+	sets := setEntries(doc)
+	if len(sets) == 0 {
+		return
+	}
+	root := sets[0].Parent
+	sort.SliceStable(sets, func(i, j int) bool { return findAttr(sets[i], "name") < findAttr(sets[j], "name") })
+	for _, set := range sets {
+		xmlquery.RemoveFromTree(set)
+	}
+	for _, set := range sets {
+		xmlquery.AddChild(root, set)
+	}
+}
+
+//dropHashAttrs removes the given hash attributes from every rom and disk of the document
+func dropHashAttrs(doc *xmlquery.Node, methods []string) {
+	if len(methods) == 0 {
+		return
+	}
+	for _, game := range setEntries(doc) {
+		for entry := game.FirstChild; entry != nil; entry = entry.NextSibling {
+			if entry.Type != xmlquery.ElementNode || (entry.Data != "rom" && entry.Data != "disk") {
+				continue
+			}
+			for _, method := range methods {
+				entry.RemoveAttr(method)
+			}
+		}
+	}
+}
+
+//unusedHashMethods returns the hash methods that no rom or disk of the document has a value for
+func unusedHashMethods(doc *xmlquery.Node) []string {
+	used := make(map[string]struct{})
+	for _, game := range setEntries(doc) {
+		for entry := game.FirstChild; entry != nil; entry = entry.NextSibling {
+			if entry.Type != xmlquery.ElementNode || (entry.Data != "rom" && entry.Data != "disk") {
+				continue
+			}
+			for _, method := range hashMethodNames() {
+				if findAttr(entry, method) != "" {
+					used[method] = struct{}{}
+				}
+			}
+		}
+	}
+	var unused []string
+	for _, method := range hashMethodNames() {
+		if _, ok := used[method]; !ok {
+			unused = append(unused, method)
+		}
+	}
+	return unused
+}
+
+//writeDatCSV writes one row for each rom of the document, along with the set it belongs to
+func writeDatCSV(w io.Writer, doc *xmlquery.Node, methods []string) error {
+	writer := csv.NewWriter(w)
+	columns := append([]string{"game", "description", "rom", "size"}, methods...)
+	columns = append(columns, "status")
+	writer.Write(columns)
+	for _, game := range setEntries(doc) {
+		description := ""
+		if node := xmlquery.FindOne(game, "description"); node != nil {
+			description = node.InnerText()
+		}
+		for rom := game.FirstChild; rom != nil; rom = rom.NextSibling {
+			if rom.Type != xmlquery.ElementNode || rom.Data != "rom" {
+				continue
+			}
+			row := []string{findAttr(game, "name"), description, findAttr(rom, "name"), findAttr(rom, "size")}
+			for _, method := range methods {
+				row = append(row, findAttr(rom, method))
+			}
+			row = append(row, findAttr(rom, "status"))
+			writer.Write(row)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//elementJSON converts an element to a map of its attributes, its text-only children as strings and
+//its other children as lists keyed by element name
+func elementJSON(node *xmlquery.Node) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, attr := range node.Attr {
+		result[attr.Name.Local] = attr.Value
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != xmlquery.ElementNode {
+			continue
+		}
+		if len(child.Attr) == 0 && (child.FirstChild == nil || child.FirstChild.Type == xmlquery.TextNode) {
+			result[child.Data] = child.InnerText()
+			continue
+		}
+		list, _ := result[child.Data].([]map[string]interface{})
+		result[child.Data] = append(list, elementJSON(child))
+	}
+	return result
+}
+
+//writeDatJSON writes the header and sets of the document as a json object
+func writeDatJSON(w io.Writer, doc *xmlquery.Node) error {
+	datJSON := struct {
+		Header map[string]interface{}   `json:"header,omitempty"`
+		Games  []map[string]interface{} `json:"games"`
+	}{Games: make([]map[string]interface{}, 0)}
+	if header := xmlquery.FindOne(doc, "/*/header"); header != nil {
+		datJSON.Header = elementJSON(header)
+	}
+	for _, game := range setEntries(doc) {
+		gameJSON := elementJSON(game)
+		gameJSON["type"] = game.Data
+		datJSON.Games = append(datJSON.Games, gameJSON)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(datJSON)
+}
+
+func (x *convertCommand) Execute(args []string) error {
+	if len(dats) != 1 {
+		message(levelError, "convert requires a single datfile, %d were given", len(dats))
+		return errors.New("convert requires a single datfile")
+	}
+	doc := dats[0].Doc
+
+	if convertCmd.SortSets {
+		sortSetEntries(doc)
+	}
+	dropHashes := convertCmd.DropHashes
+	if convertCmd.DropUnused {
+		dropHashes = append(unusedHashMethods(doc), dropHashes...)
+	}
+	if len(dropHashes) > 0 {
+		message(levelInfo, "Dropping hash attributes %s", strings.Join(dropHashes, ", "))
+	}
+	dropHashAttrs(doc, dropHashes)
+
+	dropped := make(map[string]struct{})
+	for _, method := range dropHashes {
+		dropped[method] = struct{}{}
+	}
+	var methods []string
+	for _, method := range hashMethodNames() {
+		if _, ok := dropped[method]; !ok {
+			methods = append(methods, method)
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if convertCmd.OutputFile != "" {
+		f, err := os.Create(convertCmd.OutputFile)
+		if err != nil {
+			message(levelError, "%s could not be created : %s", convertCmd.OutputFile, err)
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch convertCmd.Format {
+	case "clrmamepro":
+		return writeClrMameProDat(w, doc)
+	case "csv":
+		return writeDatCSV(w, doc, methods)
+	case "json":
+		return writeDatJSON(w, doc)
+	}
+	return writeDatXML(w, doc)
+}
+
+func init() {
+	cmd, err := parser.AddCommand("convert",
+		"Convert a datfile to another format",
+		"This command will write the datfile as a logiqx xml, clrmamepro, csv or json file",
+		&convertCmd)
+	errorExit(err)
+	setOptionChoices(cmd, "drop-hash", hashMethodNames())
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+type datdiffCommand struct {
+	JSON       bool   `short:"j" long:"json" description:"write the changes as json"`
+	OutputFile string `short:"o" long:"output" description:"file for output"`
+	Positional struct {
+		OldDat string `description:"dat file to compare from" required:"true"`
+		NewDat string `description:"dat file to compare to" required:"true"`
+	} `positional-args:"true" required:"true"`
+}
+
+var datdiffCmd datdiffCommand
+
+func (x *datdiffCommand) standalone() {}
+
+type renamedSet struct {
+	OldName string `json:"oldName"`
+	NewName string `json:"newName"`
+}
+
+type romChange struct {
+	Set     string            `json:"set"`
+	Rom     string            `json:"rom"`
+	Change  string            `json:"change"`
+	OldHash map[string]string `json:"oldHash,omitempty"`
+	NewHash map[string]string `json:"newHash,omitempty"`
+	OldSize string            `json:"oldSize,omitempty"`
+	NewSize string            `json:"newSize,omitempty"`
+}
+
+type movedRom struct {
+	Rom      string   `json:"rom"`
+	Hash     string   `json:"hash"`
+	FromSets []string `json:"fromSets"`
+	ToSets   []string `json:"toSets"`
+}
+
+//datDiff holds the changes between two versions of a dat
+type datDiff struct {
+	OldDat      string       `json:"oldDat"`
+	NewDat      string       `json:"newDat"`
+	AddedSets   []string     `json:"addedSets"`
+	RemovedSets []string     `json:"removedSets"`
+	RenamedSets []renamedSet `json:"renamedSets"`
+	ChangedRoms []romChange  `json:"changedRoms"`
+	MovedRoms   []movedRom   `json:"movedRoms"`
+}
+
+//romKey identifies a rom by the key method, falling back to the crc and size
+func romKey(rom *xmlquery.Node, keyMethod string) string {
+	if value := findAttr(rom, keyMethod); keyMethod != "crc" && value != "" {
+		return keyMethod + ":" + strings.ToLower(value)
+	}
+	return "crc:" + strings.ToLower(findAttr(rom, "crc")) + ":" + findAttr(rom, "size")
+}
+
+//romKeyMethod returns the strongest hash method used by both dats, so that a rom keeps the same
+//key when a newer version of the dat adds a stronger hash
+func romKeyMethod(oldDat *datFile, newDat *datFile) string {
+	for i := len(hashMethods) - 1; i > 0; i-- {
+		method := hashMethods[i].Name
+		if len(oldDat.Index.romsByAttr[method]) > 0 && len(newDat.Index.romsByAttr[method]) > 0 {
+			return method
+		}
+	}
+	return "crc"
+}
+
+//romHashes returns the hash attributes of a rom
+func romHashes(rom *xmlquery.Node) map[string]string {
+	hashes := make(map[string]string)
+	for _, method := range hashMethodNames() {
+		if value := findAttr(rom, method); value != "" {
+			hashes[method] = strings.ToLower(value)
+		}
+	}
+	return hashes
+}
+
+//setSignature identifies a set by the hashes of its roms so that renamed sets can be found
+func setSignature(game *xmlquery.Node, keyMethod string) string {
+	var keys []string
+	for rom := range childNodeSet(game, "rom") {
+		keys = append(keys, romKey(rom, keyMethod))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "|")
+}
+
+//uniqueGames returns the sets of a dat in datfile order and keyed by name, ignoring duplicates
+func uniqueGames(dat *datFile) ([]string, map[string]*xmlquery.Node) {
+	var names []string
+	games := make(map[string]*xmlquery.Node)
+	for _, game := range findGameEntries(dat.Index) {
+		name := findAttr(game, "name")
+		if _, ok := games[name]; !ok {
+			names = append(names, name)
+			games[name] = game
+		}
+	}
+	return names, games
+}
+
+func diffDats(oldDat *datFile, newDat *datFile) *datDiff {
+	diff := &datDiff{
+		OldDat:      oldDat.Path,
+		NewDat:      newDat.Path,
+		AddedSets:   []string{},
+		RemovedSets: []string{},
+		RenamedSets: []renamedSet{},
+		ChangedRoms: []romChange{},
+	}
+	keyMethod := romKeyMethod(oldDat, newDat)
+	oldNames, oldGames := uniqueGames(oldDat)
+	newNames, newGames := uniqueGames(newDat)
+
+	//sets with a name that is no longer used are renamed if a new set has the same roms
+	added := make(map[string]string)
+	for _, name := range newNames {
+		if _, ok := oldGames[name]; !ok {
+			if signature := setSignature(newGames[name], keyMethod); signature != "" {
+				added[signature] = name
+			}
+		}
+	}
+	renamedTo := make(map[string]string)
+	for _, name := range oldNames {
+		if _, ok := newGames[name]; ok {
+			continue
+		}
+		signature := setSignature(oldGames[name], keyMethod)
+		if newName, ok := added[signature]; ok && signature != "" {
+			diff.RenamedSets = append(diff.RenamedSets, renamedSet{name, newName})
+			renamedTo[name] = newName
+			delete(added, signature)
+		} else {
+			diff.RemovedSets = append(diff.RemovedSets, name)
+		}
+	}
+	renamedFrom := make(map[string]struct{})
+	for _, newName := range renamedTo {
+		renamedFrom[newName] = struct{}{}
+	}
+	for _, name := range newNames {
+		_, existing := oldGames[name]
+		_, renamed := renamedFrom[name]
+		if !existing && !renamed {
+			diff.AddedSets = append(diff.AddedSets, name)
+		}
+	}
+
+	for _, name := range oldNames {
+		if newGame, ok := newGames[name]; ok {
+			diff.ChangedRoms = append(diff.ChangedRoms, diffRoms(name, oldGames[name], newGame)...)
+		}
+	}
+	diff.MovedRoms = findMovedRoms(oldDat, newDat, renamedTo, keyMethod)
+	return diff
+}
+
+//diffRoms compares the roms of a set that is in both dats by name
+func diffRoms(setName string, oldGame *xmlquery.Node, newGame *xmlquery.Node) []romChange {
+	oldRoms := make(map[string]*xmlquery.Node)
+	for rom := oldGame.FirstChild; rom != nil; rom = rom.NextSibling {
+		if rom.Type == xmlquery.ElementNode && rom.Data == "rom" {
+			oldRoms[findAttr(rom, "name")] = rom
+		}
+	}
+
+	var changes []romChange
+	seen := make(map[string]struct{})
+	for rom := newGame.FirstChild; rom != nil; rom = rom.NextSibling {
+		if rom.Type != xmlquery.ElementNode || rom.Data != "rom" {
+			continue
+		}
+		romName := findAttr(rom, "name")
+		seen[romName] = struct{}{}
+		oldRom, ok := oldRoms[romName]
+		if !ok {
+			changes = append(changes, romChange{setName, romName, "added", nil, romHashes(rom), "", findAttr(rom, "size")})
+			continue
+		}
+		oldHashes := romHashes(oldRom)
+		newHashes := romHashes(rom)
+		oldSize := findAttr(oldRom, "size")
+		newSize := findAttr(rom, "size")
+		switch {
+		case oldSize != newSize:
+			changes = append(changes, romChange{setName, romName, "size", oldHashes, newHashes, oldSize, newSize})
+		case hashesChanged(oldHashes, newHashes):
+			changes = append(changes, romChange{setName, romName, "hash", oldHashes, newHashes, oldSize, newSize})
+		}
+	}
+	for rom := oldGame.FirstChild; rom != nil; rom = rom.NextSibling {
+		if rom.Type != xmlquery.ElementNode || rom.Data != "rom" {
+			continue
+		}
+		if _, ok := seen[findAttr(rom, "name")]; !ok {
+			changes = append(changes, romChange{setName, findAttr(rom, "name"), "removed", romHashes(rom), nil, findAttr(rom, "size"), ""})
+		}
+	}
+	return changes
+}
+
+//hashesChanged returns true if a hash that both versions of a rom have differs, or if a hash was
+//added or removed
+func hashesChanged(oldHashes map[string]string, newHashes map[string]string) bool {
+	if len(oldHashes) != len(newHashes) {
+		return true
+	}
+	for method, value := range newHashes {
+		if oldValue, ok := oldHashes[method]; !ok || oldValue != value {
+			return true
+		}
+	}
+	return false
+}
+
+//findMovedRoms finds roms that are no longer in the sets that held them and are now in other sets,
+//ignoring sets that were renamed
+func findMovedRoms(oldDat *datFile, newDat *datFile, renamedTo map[string]string, keyMethod string) []movedRom {
+	oldSets := make(map[string]map[string]struct{})
+	romNames := make(map[string]string)
+	var keys []string
+	for _, rom := range oldDat.Index.roms {
+		key := romKey(rom, keyMethod)
+		if _, ok := oldSets[key]; !ok {
+			oldSets[key] = make(map[string]struct{})
+			keys = append(keys, key)
+			romNames[key] = findAttr(rom, "name")
+		}
+		setName := findAttr(rom.Parent, "name")
+		if newName, ok := renamedTo[setName]; ok {
+			setName = newName
+		}
+		oldSets[key][setName] = struct{}{}
+	}
+	newSets := make(map[string]map[string]struct{})
+	for _, rom := range newDat.Index.roms {
+		key := romKey(rom, keyMethod)
+		if _, ok := newSets[key]; !ok {
+			newSets[key] = make(map[string]struct{})
+		}
+		newSets[key][findAttr(rom.Parent, "name")] = struct{}{}
+	}
+
+	moved := []movedRom{}
+	for _, key := range keys {
+		to, ok := newSets[key]
+		if !ok {
+			continue
+		}
+		fromSets := setDifference(oldSets[key], to)
+		toSets := setDifference(to, oldSets[key])
+		if len(fromSets) > 0 && len(toSets) > 0 {
+			moved = append(moved, movedRom{romNames[key], key, fromSets, toSets})
+		}
+	}
+	return moved
+}
+
+//setDifference returns the sorted names in a that are not in b
+func setDifference(a map[string]struct{}, b map[string]struct{}) []string {
+	var names []string
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printDatDiff(diff *datDiff) {
+	output("--ADDED SETS--")
+	for _, name := range diff.AddedSets {
+		output("[ADD ]  %s", name)
+	}
+	output("--REMOVED SETS--")
+	for _, name := range diff.RemovedSets {
+		output("[DEL ]  %s", name)
+	}
+	output("--RENAMED SETS--")
+	for _, renamed := range diff.RenamedSets {
+		output("[REN ]  %s -> %s", renamed.OldName, renamed.NewName)
+	}
+	output("--CHANGED ROMS--")
+	for _, change := range diff.ChangedRoms {
+		switch change.Change {
+		case "added":
+			output("[ADD ]  %s: %s", change.Set, change.Rom)
+		case "removed":
+			output("[DEL ]  %s: %s", change.Set, change.Rom)
+		default:
+			if change.Change == "size" {
+				output("[SIZE]  %s: %s", change.Set, change.Rom)
+				outputIndent(2, "size: %s -> %s", change.OldSize, change.NewSize)
+			} else {
+				output("[HASH]  %s: %s", change.Set, change.Rom)
+			}
+			for _, method := range hashMethodNames() {
+				if change.OldHash[method] != change.NewHash[method] {
+					outputIndent(2, "%s: %s -> %s", method, change.OldHash[method], change.NewHash[method])
+				}
+			}
+		}
+	}
+	output("--MOVED ROMS--")
+	for _, moved := range diff.MovedRoms {
+		output("[MOVE]  %s from %s to %s", moved.Rom, strings.Join(moved.FromSets, ", "), strings.Join(moved.ToSets, ", "))
+	}
+	output("--DIFF STATISTICS--")
+	output("\tAdded sets: %d", len(diff.AddedSets))
+	output("\tRemoved sets: %d", len(diff.RemovedSets))
+	output("\tRenamed sets: %d", len(diff.RenamedSets))
+	output("\tChanged roms: %d", len(diff.ChangedRoms))
+	output("\tMoved roms: %d", len(diff.MovedRoms))
+}
+
+func (x *datdiffCommand) Execute(args []string) error {
+	if datdiffCmd.OutputFile != "" {
+		f, err := os.Create(datdiffCmd.OutputFile)
+		if err != nil {
+			message(levelError, "%s could not be created : %s", datdiffCmd.OutputFile, err)
+			return err
+		}
+		defer f.Close()
+		outputFile = f
+	}
+
+	oldDat := loadDatFile(datdiffCmd.Positional.OldDat, true)
+	newDat := loadDatFile(datdiffCmd.Positional.NewDat, true)
+	diff := diffDats(oldDat, newDat)
+
+	if datdiffCmd.JSON {
+		encoder := json.NewEncoder(outputFile)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	}
+	printDatDiff(diff)
+	return nil
+}
+
+func init() {
+	parser.AddCommand("datdiff",
+		"Compare two versions of a datfile",
+		"This command will list the sets and roms that were added, removed, renamed, changed or moved between two datfiles",
+		&datdiffCmd)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+type filterCommand struct {
+	AnyRegion     bool     `long:"any-region" description:"keep the best set of a group even if it has none of the preferred regions"`
+	GroupByTitle  bool     `long:"group-by-title" description:"group sets by the title in their name rather than by cloneof, for dats without parent/clone information"`
+	IncludeBetas  bool     `long:"include-betas" description:"consider beta sets when selecting"`
+	IncludeDemos  bool     `long:"include-demos" description:"consider demo, sample and kiosk sets when selecting"`
+	IncludeProtos bool     `long:"include-protos" description:"consider prototype sets when selecting"`
+	Languages     []string `long:"language" description:"language in order of preference (can be specified multiple times)" default:"En"`
+	OutputFile    string   `short:"o" long:"output" description:"file for output (default: stdout)"`
+	Regions       []string `long:"region" description:"region in order of preference (can be specified multiple times)" default:"USA" default:"Europe" default:"Japan"`
+}
+
+var filterCmd filterCommand
+
+//filterCandidate is a set that can be selected as the one set of its group
+type filterCandidate struct {
+	Game  *xmlquery.Node
+	Tags  nameTags
+	Order int
+}
+
+//isFilteredOut returns true if the set is a kind of release that has not been asked for
+func (x *filterCommand) isFilteredOut(tags nameTags) bool {
+	return (!x.IncludeBetas && tags.hasFlag("Beta")) ||
+		(!x.IncludeProtos && tags.hasFlag("Proto", "Prototype")) ||
+		(!x.IncludeDemos && tags.hasFlag("Demo", "Sample", "Kiosk"))
+}
+
+//regionRank returns the position of the most preferred region of the set, where World counts as
+//the first preference unless it has been given its own place
+func (x *filterCommand) regionRank(tags nameTags) int {
+	for i, region := range x.Regions {
+		if containsString(tags.Regions, region) {
+			return i
+		}
+	}
+	if containsString(tags.Regions, "World") {
+		return 0
+	}
+	return len(x.Regions)
+}
+
+//languageRank returns the position of the most preferred language of the set, sets without
+//language tags are assumed to be in the language of their region
+func (x *filterCommand) languageRank(tags nameTags) int {
+	if len(tags.Languages) == 0 {
+		return 0
+	}
+	for i, language := range x.Languages {
+		if containsString(tags.Languages, language) {
+			return i
+		}
+	}
+	return len(x.Languages)
+}
+
+//isBetterCandidate returns true if a should be chosen over b
+func (x *filterCommand) isBetterCandidate(a filterCandidate, b filterCandidate) bool {
+	if rankA, rankB := x.regionRank(a.Tags), x.regionRank(b.Tags); rankA != rankB {
+		return rankA < rankB
+	}
+	if rankA, rankB := x.languageRank(a.Tags), x.languageRank(b.Tags); rankA != rankB {
+		return rankA < rankB
+	}
+	if flagsA, flagsB := len(a.Tags.Flags), len(b.Tags.Flags); flagsA != flagsB {
+		return flagsA < flagsB
+	}
+	if cmp := compareVersions(a.Tags.Revision, b.Tags.Revision); cmp != 0 {
+		return cmp > 0
+	}
+	if cmp := compareVersions(a.Tags.Version, b.Tags.Version); cmp != 0 {
+		return cmp > 0
+	}
+	if parentA, parentB := findAttr(a.Game, "cloneof") == "", findAttr(b.Game, "cloneof") == ""; parentA != parentB {
+		return parentA
+	}
+	return a.Order < b.Order
+}
+
+//selectGames groups the sets of the dat and chooses the best set of each group
+func (x *filterCommand) selectGames(index *romIndex) map[*xmlquery.Node]struct{} {
+	groups := make(map[string][]filterCandidate)
+	var groupNames []string
+	for i, game := range findGameEntries(index) {
+		tags := gameTags(game)
+		groupName := cloneRoot(index, game)
+		if x.GroupByTitle {
+			groupName = strings.ToLower(tags.Title)
+		}
+		if _, ok := groups[groupName]; !ok {
+			groupNames = append(groupNames, groupName)
+			groups[groupName] = nil
+		}
+		if x.isFilteredOut(tags) {
+			message(levelDebug, "%s is excluded by its tags", findAttr(game, "name"))
+			continue
+		}
+		groups[groupName] = append(groups[groupName], filterCandidate{game, tags, i})
+	}
+
+	selected := make(map[*xmlquery.Node]struct{})
+	for _, groupName := range groupNames {
+		var best *filterCandidate
+		for i, candidate := range groups[groupName] {
+			if best == nil || x.isBetterCandidate(candidate, *best) {
+				best = &groups[groupName][i]
+			}
+		}
+		if best == nil {
+			message(levelInfo, "%s has no sets left after filtering", groupName)
+			continue
+		}
+		if !x.AnyRegion && x.regionRank(best.Tags) == len(x.Regions) {
+			message(levelInfo, "%s has no sets in a preferred region", groupName)
+			continue
+		}
+		message(levelInfo, "%s selected for %s", findAttr(best.Game, "name"), groupName)
+		selected[best.Game] = struct{}{}
+		for _, dep := range setDependencies(index, best.Game) {
+			selected[dep] = struct{}{}
+		}
+	}
+	return selected
+}
+
+//removeUnselectedGames removes the sets that were not selected from the document, along with any
+//cloneof or romof attributes that refer to them
+func removeUnselectedGames(index *romIndex, selected map[*xmlquery.Node]struct{}) {
+	kept := make(map[string]struct{})
+	for _, game := range findGameEntries(index) {
+		if _, ok := selected[game]; ok {
+			kept[findAttr(game, "name")] = struct{}{}
+		} else {
+			xmlquery.RemoveFromTree(game)
+		}
+	}
+	for game := range selected {
+		for _, attrName := range []string{"cloneof", "romof"} {
+			if value := findAttr(game, attrName); value != "" {
+				if _, ok := kept[value]; !ok {
+					game.RemoveAttr(attrName)
+				}
+			}
+		}
+	}
+}
+
+func (x *filterCommand) Execute(args []string) error {
+	if len(dats) != 1 {
+		message(levelError, "filter requires a single datfile, %d were given", len(dats))
+		return errors.New("filter requires a single datfile")
+	}
+	dat := dats[0]
+
+	selected := filterCmd.selectGames(dat.Index)
+	message(levelInfo, "Selected %d of %d sets", len(selected), len(findGameEntries(dat.Index)))
+	removeUnselectedGames(dat.Index, selected)
+
+	w := io.Writer(os.Stdout)
+	if filterCmd.OutputFile != "" {
+		f, err := os.Create(filterCmd.OutputFile)
+		if err != nil {
+			message(levelError, "%s could not be created : %s", filterCmd.OutputFile, err)
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return writeDatXML(w, dat.Doc)
+}
+
+func init() {
+	parser.AddCommand("filter",
+		"Select one set for each game from a datfile",
+		"This command will group parent and clone sets, choose the best set of each group using the region and language tags in the set names and write the chosen sets as a new datfile",
+		&filterCmd)
+}
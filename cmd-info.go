@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/antchfx/xmlquery"
+)
+
+type infoCommand struct{}
+
+var infoCmd infoCommand
+
+//headerFields are the logiqx header fields in the order that they are shown
+var headerFields = []string{"name", "description", "category", "version", "date", "author", "email", "homepage", "url", "comment"}
+
+func (x *infoCommand) Execute(args []string) error {
+	for i, dat := range dats {
+		if i > 0 {
+			output("----")
+		}
+		printDatInfo(dat)
+	}
+	return nil
+}
+
+func printDatInfo(dat *datFile) {
+	output("--HEADER--")
+	output("\tfile: %s", dat.Path)
+	if root := xmlquery.FindOne(dat.Doc, "/*"); root != nil {
+		output("\troot: %s", root.Data)
+		printEntryAttributes(root, 2)
+	}
+	header := xmlquery.FindOne(dat.Doc, "/*/header")
+	if header != nil {
+		for _, field := range headerFields {
+			if el := header.SelectElement(field); el != nil {
+				output("\t%s: %s", field, el.InnerText())
+			}
+		}
+		for _, settings := range []string{"clrmamepro", "romcenter"} {
+			if el := header.SelectElement(settings); el != nil {
+				output("\t%s:", settings)
+				printEntryAttributes(el, 2)
+			}
+		}
+	}
+
+	sets := findGameEntries(dat.Index)
+	disks := 0
+	for _, game := range sets {
+		disks += len(childNodeSet(game, "disk"))
+	}
+
+	var totalSize uint64
+	missingHashes := make(map[string]int)
+	for _, rom := range dat.Index.roms {
+		size, err := strconv.ParseUint(findAttr(rom, "size"), 10, 64)
+		if err == nil {
+			totalSize += size
+		}
+		for _, method := range hashMethodNames() {
+			if findAttr(rom, method) == "" {
+				missingHashes[method]++
+			}
+		}
+	}
+
+	output("--STATISTICS--")
+	output("\tSets: %d", len(sets))
+	output("\tRoms: %d", len(dat.Index.roms))
+	output("\tDisks: %d", disks)
+	output("\tTotal size: %s (%d bytes)", iecPrefix(totalSize), totalSize)
+	for _, method := range hashMethodNames() {
+		output("\tRoms without %s: %d", method, missingHashes[method])
+	}
+}
+
+func init() {
+	parser.AddCommand("info",
+		"Show datfile information",
+		"This command will show the header of the datfile and statistics about the sets and roms it contains",
+		&infoCmd)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+type lintCommand struct {
+	OutputFile string `short:"o" long:"output" description:"file for output"`
+	Positional struct {
+		Dats []string `description:"dat files to check" required:"1"`
+	} `positional-args:"true" required:"true"`
+}
+
+var lintCmd lintCommand
+
+func (x *lintCommand) standalone() {}
+
+//lintSet is a set as written in the dat file, keeping the line numbers that the parsed document
+//does not have
+type lintSet struct {
+	Name    string
+	CloneOf string
+	RomOf   string
+	Line    int
+	Items   []lintItem
+}
+
+//lintItem is a rom or disk entry of a set
+type lintItem struct {
+	Kind  string
+	Line  int
+	Attrs map[string]string
+}
+
+//lintSets reads the sets of a dat file in any of the supported formats
+func lintSets(datPath string) ([]*lintSet, error) {
+	f, err := openDat(datPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	switch detectDatFormat(reader) {
+	case formatClrMamePro:
+		return lintClrMameProSets(reader)
+	case formatRomCenter:
+		return lintRomCenterSets(reader)
+	}
+	return lintXMLSets(reader)
+}
+
+func lintXMLSets(reader io.Reader) ([]*lintSet, error) {
+	decoder := xml.NewDecoder(reader)
+	decoder.CharsetReader = charset.NewReaderLabel
+	var sets []*lintSet
+	var current *lintSet
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return sets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := decoder.InputPos()
+		switch element := token.(type) {
+		case xml.StartElement:
+			depth++
+			attrs := make(map[string]string)
+			for _, attr := range element.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+			switch {
+			case depth == 2 && (element.Name.Local == "game" || element.Name.Local == "machine" || element.Name.Local == "software"):
+				current = &lintSet{attrs["name"], attrs["cloneof"], attrs["romof"], line, nil}
+				sets = append(sets, current)
+			case current != nil && (element.Name.Local == "rom" || element.Name.Local == "disk"):
+				//software lists continue or fill the previous rom with unnamed rom entries
+				if attrs["name"] == "" && attrs["loadflag"] != "" {
+					break
+				}
+				current.Items = append(current.Items, lintItem{element.Name.Local, line, attrs})
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				current = nil
+			}
+			depth--
+		}
+	}
+}
+
+func lintClrMameProSets(reader *bufio.Reader) ([]*lintSet, error) {
+	entries, err := readClrMameProEntries(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []*lintSet
+	for _, entry := range entries {
+		if entry.Key != "game" && entry.Key != "machine" && entry.Key != "resource" {
+			continue
+		}
+		set := &lintSet{Line: entry.Line}
+		for _, child := range entry.Block {
+			switch {
+			case child.Key == "name":
+				set.Name = child.Value
+			case child.Key == "cloneof":
+				set.CloneOf = child.Value
+			case child.Key == "romof":
+				set.RomOf = child.Value
+			case (child.Key == "rom" || child.Key == "disk") && child.Block != nil:
+				attrs := make(map[string]string)
+				for _, value := range child.Block {
+					attrs[value.Key] = value.Value
+				}
+				set.Items = append(set.Items, lintItem{child.Key, child.Line, attrs})
+			}
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+func lintRomCenterSets(reader *bufio.Reader) ([]*lintSet, error) {
+	var sets []*lintSet
+	setMap := make(map[string]*lintSet)
+	section := ""
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		trimmed := strings.TrimSpace(strings.TrimPrefix(romCenterText(line), "\ufeff"))
+		switch {
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			section = strings.ToUpper(strings.Trim(trimmed, "[]"))
+		case section == "GAMES" && trimmed != "":
+			fields, fieldErr := romCenterFields(trimmed)
+			if fieldErr != nil {
+				return nil, fmt.Errorf("%s on line %d", fieldErr, lineNumber)
+			}
+			set, ok := setMap[fields[rcGameName]]
+			if !ok {
+				set = &lintSet{Name: fields[rcGameName], Line: lineNumber}
+				if parent := fields[rcParentName]; parent != set.Name {
+					set.CloneOf = parent
+				}
+				if romOf := fields[rcRomOf]; romOf != set.Name {
+					set.RomOf = romOf
+				}
+				setMap[set.Name] = set
+				sets = append(sets, set)
+			}
+			set.Items = append(set.Items, lintItem{"rom", lineNumber, map[string]string{
+				"name": fields[rcRomName], "size": fields[rcRomSize], "crc": fields[rcRomCrc],
+			}})
+		}
+		if err == io.EOF {
+			return sets, nil
+		}
+	}
+}
+
+//datLinter collects the problems found in a dat
+type datLinter struct {
+	Path     string
+	Errors   int
+	Warnings int
+}
+
+func (linter *datLinter) errorf(line int, format string, args ...interface{}) {
+	linter.Errors++
+	output("[BAD ]  %s:%d: %s", linter.Path, line, fmt.Sprintf(format, args...))
+}
+
+func (linter *datLinter) warnf(line int, format string, args ...interface{}) {
+	linter.Warnings++
+	output("[WARN]  %s:%d: %s", linter.Path, line, fmt.Sprintf(format, args...))
+}
+
+func (linter *datLinter) lint(sets []*lintSet) {
+	setLines := make(map[string]int)
+	for _, set := range sets {
+		if set.Name == "" {
+			linter.errorf(set.Line, "set has no name")
+		} else if firstLine, ok := setLines[set.Name]; ok {
+			linter.errorf(set.Line, "duplicate set name %q (first on line %d)", set.Name, firstLine)
+		} else {
+			setLines[set.Name] = set.Line
+		}
+	}
+
+	for _, set := range sets {
+		if set.CloneOf != "" {
+			if _, ok := setLines[set.CloneOf]; !ok {
+				linter.errorf(set.Line, "set %q is a clone of missing set %q", set.Name, set.CloneOf)
+			}
+		}
+		if set.RomOf != "" && set.RomOf != set.CloneOf {
+			if _, ok := setLines[set.RomOf]; !ok {
+				linter.errorf(set.Line, "set %q takes roms from missing set %q", set.Name, set.RomOf)
+			}
+		}
+		if len(set.Items) == 0 {
+			linter.warnf(set.Line, "set %q has no roms", set.Name)
+		}
+
+		itemLines := make(map[string]int)
+		for _, item := range set.Items {
+			linter.lintItem(set, item, itemLines)
+		}
+	}
+}
+
+func (linter *datLinter) lintItem(set *lintSet, item lintItem, itemLines map[string]int) {
+	name := item.Attrs["name"]
+	key := item.Kind + ":" + name
+	if name == "" {
+		linter.errorf(item.Line, "%s in set %q has no name", item.Kind, set.Name)
+	} else if firstLine, ok := itemLines[key]; ok {
+		linter.errorf(item.Line, "duplicate %s name %q in set %q (first on line %d)", item.Kind, name, set.Name, firstLine)
+	} else {
+		itemLines[key] = item.Line
+	}
+
+	if item.Kind == "rom" {
+		size, ok := item.Attrs["size"]
+		if !ok || size == "" {
+			linter.errorf(item.Line, "rom %q in set %q has no size", name, set.Name)
+		} else if _, err := strconv.ParseUint(size, 0, 64); err != nil {
+			linter.errorf(item.Line, "rom %q in set %q has invalid size %q", name, set.Name, size)
+		}
+	}
+
+	for _, method := range hashMethods {
+		value := item.Attrs[method.Name]
+		if value == "" {
+			continue
+		}
+		if _, err := hex.DecodeString(value); err != nil || len(value) != method.New().Size()*2 {
+			linter.errorf(item.Line, "%s %q in set %q has malformed %s %q", item.Kind, name, set.Name, method.Name, value)
+		}
+	}
+}
+
+func (x *lintCommand) Execute(args []string) error {
+	if lintCmd.OutputFile != "" {
+		f, err := os.Create(lintCmd.OutputFile)
+		if err != nil {
+			message(levelError, "%s could not be created : %s", lintCmd.OutputFile, err)
+			return err
+		}
+		defer f.Close()
+		outputFile = f
+	}
+
+	var datPaths []string
+	for _, datPath := range lintCmd.Positional.Dats {
+		datPaths = append(datPaths, datFilesAtPath(datPath)...)
+	}
+
+	errors := 0
+	warnings := 0
+	for _, datPath := range datPaths {
+		linter := &datLinter{Path: datPath}
+		sets, err := lintSets(datPath)
+		if syntaxErr, ok := err.(*xml.SyntaxError); ok {
+			linter.errorf(syntaxErr.Line, "invalid xml: %s", syntaxErr.Msg)
+		} else if err != nil {
+			linter.errorf(0, "unable to read dat: %s", err)
+		} else {
+			linter.lint(sets)
+		}
+		errors += linter.Errors
+		warnings += linter.Warnings
+	}
+	output("--LINT STATISTICS--")
+	output("\tErrors: %d", errors)
+	output("\tWarnings: %d", warnings)
+
+	if errors > 0 {
+		return fmt.Errorf("found %d errors", errors)
+	}
+	return nil
+}
+
+func init() {
+	parser.AddCommand("lint",
+		"Check datfiles for problems",
+		"This command will check datfiles for duplicate names, malformed hashes, missing sizes, empty sets and missing parent sets, reporting the line of each problem",
+		&lintCmd)
+}
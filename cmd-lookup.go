@@ -3,12 +3,13 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/antchfx/xmlquery"
 )
 
 type lookupCommand struct {
-	LookupKey  string `short:"k" long:"key" description:"key to use for lookup (ignored for game mode)" choice:"name" choice:"crc" choice:"md5" choice:"sha1" default:"name"`
+	LookupKey  string `short:"k" long:"key" description:"key to use for lookup (ignored for game mode)" default:"name"`
 	LookupMode string `short:"m" long:"mode" description:"element to lookup" choice:"rom" choice:"game" default:"rom"`
 	ExactMatch bool   `short:"x" long:"exact" description:"use exact match (otherwise use substring match)"`
 	Positional struct {
@@ -19,6 +20,19 @@ type lookupCommand struct {
 var lookupCmd lookupCommand
 
 func (x *lookupCommand) Execute(args []string) error {
+	for i, dat := range dats {
+		if len(dats) > 1 {
+			if i > 0 {
+				fmt.Println("====")
+			}
+			fmt.Printf("dat: %s\n", dat.Name())
+		}
+		lookupKeys(dat.Index)
+	}
+	return nil
+}
+
+func lookupKeys(index *romIndex) {
 	for i, key := range lookupCmd.Positional.Keys {
 		if i > 0 {
 			fmt.Println("----")
@@ -26,25 +40,24 @@ func (x *lookupCommand) Execute(args []string) error {
 		var list []*xmlquery.Node
 		if lookupCmd.LookupMode == "game" {
 			if lookupCmd.ExactMatch {
-				list = matchGameEntriesByName(datfile, key)
+				list = matchGameEntriesByName(index, key)
 			} else {
-				list = findGameEntriesWithName(datfile, key)
+				list = findGameEntriesWithName(index, key)
 			}
 			printGameEntries(list)
 		} else {
 			if lookupCmd.LookupKey == "name" {
 				if lookupCmd.ExactMatch {
-					list = matchRomEntriesByName(datfile, key)
+					list = matchRomEntriesByName(index, key)
 				} else {
-					list = findRomEntriesWithName(datfile, key)
+					list = findRomEntriesWithName(index, key)
 				}
 				printRomEntries(list)
 			} else {
-				printRomEntries(matchRomEntriesByHexString(datfile, lookupCmd.LookupKey, key))
+				printRomEntries(matchRomEntriesByHexString(index, lookupCmd.LookupKey, key))
 			}
 		}
 	}
-	return nil
 }
 
 func printRomEntries(list []*xmlquery.Node) {
@@ -67,15 +80,47 @@ func printGameEntries(list []*xmlquery.Node) {
 
 func printGameEntry(game *xmlquery.Node, indent int) {
 	printEntryAttributes(game, indent)
-	for el := game.FirstChild; el != nil; el = el.NextSibling {
+	printNameTags(game, indent)
+	printChildEntries(game, indent)
+}
+
+//printChildEntries prints the child elements of a node, nesting those that have children of their
+//own such as the parts of software list entries
+func printChildEntries(node *xmlquery.Node, indent int) {
+	for el := node.FirstChild; el != nil; el = el.NextSibling {
 		if el.Type != xmlquery.ElementNode {
 			continue
 		}
-		outputIndent(indent, "%s: %s", el.Data, el.InnerText())
+		if xmlquery.FindOne(el, "*") != nil {
+			outputIndent(indent, "%s:", el.Data)
+			printEntryAttributes(el, indent+1)
+			printChildEntries(el, indent+1)
+			continue
+		}
+		outputIndent(indent, "%s: %s", el.Data, strings.TrimSpace(el.InnerText()))
 		printEntryAttributes(el, indent+1)
 	}
 }
 
+//printNameTags prints the tags parsed from the name of a game, using the same keys and values that
+//the sets report can be filtered and grouped by
+func printNameTags(game *xmlquery.Node, indent int) {
+	tags := gameTags(game)
+	printed := false
+	for _, key := range tagKeys {
+		values := tags.values(key)
+		if len(values) == 0 {
+			continue
+		}
+		if !printed {
+			outputIndent(indent, "tags:")
+			outputIndent(indent+1, "title: %s", tags.Title)
+			printed = true
+		}
+		outputIndent(indent+1, "%s: %s", key, strings.Join(values, ", "))
+	}
+}
+
 func printEntryAttributes(node *xmlquery.Node, indent int) {
 	attr := mapAttr(node)
 	if _, ok := attr["name"]; ok {
@@ -94,8 +139,10 @@ func printEntryAttributes(node *xmlquery.Node, indent int) {
 }
 
 func init() {
-	parser.AddCommand("lookup",
+	cmd, err := parser.AddCommand("lookup",
 		"Lookup a datfile rom entry",
 		"This command will search for a datfile rom entry that matches the given key (default is sha, use -m to change key type)",
 		&lookupCmd)
+	errorExit(err)
+	setOptionChoices(cmd, "key", append([]string{"name"}, hashMethodNames()...))
 }
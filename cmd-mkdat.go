@@ -0,0 +1,270 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xmlquery"
+)
+
+type mkdatCommand struct {
+	Author      string              `long:"author" description:"author of the dat"`
+	Date        string              `long:"date" description:"date of the dat (default: today)"`
+	Description string              `long:"description" description:"description of the dat (default: name)"`
+	Exclude     map[string]struct{} `short:"e" long:"exclude" description:"extension to exclude from file list (can be specified multiple times)"`
+	Hashes      []string            `long:"hash" description:"hash to include for each rom (can be specified multiple times)" default:"crc" default:"md5" default:"sha1"`
+	Homepage    string              `long:"homepage" description:"homepage of the dat"`
+	Name        string              `long:"name" description:"name of the dat (default: name of the directory)"`
+	OutputFile  string              `short:"o" long:"output" description:"file for output (default: stdout)"`
+	Version     string              `long:"version" description:"version of the dat (default: today)"`
+	Positional  struct {
+		Dir string `description:"directory to create the dat from (default: .)"`
+	} `positional-args:"true"`
+}
+
+var mkdatCmd mkdatCommand
+
+//mkdatOutput is the file that the dat is written to, if it is a file that could be in the directory
+var mkdatOutput os.FileInfo
+
+func (x *mkdatCommand) standalone() {}
+
+//isExcludedFromDat returns true if the file should be left out of the dat
+func isExcludedFromDat(filePath string) bool {
+	if strings.HasPrefix(filepath.Base(filePath), ".") {
+		return true
+	}
+	fileExt := strings.TrimPrefix(filepath.Ext(filePath), ".")
+	if _, ok := mkdatCmd.Exclude[fileExt]; ok {
+		message(levelInfo, "%s has excluded extension, skipping.", filePath)
+		return true
+	}
+	return false
+}
+
+//isDatOutput returns true if the file is the one the dat is written to, whether it is given as
+//the output file or the standard output was redirected to it
+func isDatOutput(filePath string) bool {
+	if mkdatOutput == nil {
+		return false
+	}
+	fileInfo, err := os.Stat(filePath)
+	return err == nil && os.SameFile(fileInfo, mkdatOutput)
+}
+
+//findDatOutput returns the file that the dat is written to, or nil when it is not a regular file
+func findDatOutput() os.FileInfo {
+	var fileInfo os.FileInfo
+	var err error
+	if mkdatCmd.OutputFile != "" {
+		fileInfo, err = os.Stat(mkdatCmd.OutputFile)
+	} else {
+		fileInfo, err = os.Stdout.Stat()
+	}
+	if err != nil || !fileInfo.Mode().IsRegular() {
+		return nil
+	}
+	return fileInfo
+}
+
+//addRomEntry hashes the file and adds it to the game as a rom, or as a disk for chd images
+func addRomEntry(game *xmlquery.Node, romName string, size int64, reader io.Reader, filePath string) {
+	if strings.EqualFold(filepath.Ext(romName), ".chd") && filePath != "" {
+		sha1, _, err := readChdSha1(filePath)
+		if err == nil {
+			disk := addElement(game, "disk")
+			xmlquery.AddAttr(disk, "name", strings.TrimSuffix(romName, filepath.Ext(romName)))
+			xmlquery.AddAttr(disk, "sha1", sha1)
+			return
+		}
+		message(levelWarn, "%s could not be read as a chd, adding as a rom. Reason: %s", filePath, err)
+	}
+
+	hashes := hashFileAll(reader, mkdatCmd.Hashes)
+	rom := addElement(game, "rom")
+	xmlquery.AddAttr(rom, "name", romName)
+	xmlquery.AddAttr(rom, "size", strconv.FormatInt(size, 10))
+	for _, method := range hashMethodNames() {
+		if value, ok := hashes[method]; ok {
+			xmlquery.AddAttr(rom, method, value)
+		}
+	}
+}
+
+func addGameEntry(root *xmlquery.Node, gameName string) *xmlquery.Node {
+	game := addElement(root, "game")
+	xmlquery.AddAttr(game, "name", gameName)
+	addTextElement(game, "description", gameName)
+	return game
+}
+
+//addFileGame adds a loose file as a game containing a single rom
+func addFileGame(root *xmlquery.Node, filePath string, fileInfo os.FileInfo) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		message(levelError, "%s could not be opened, skipping. Reason: %s", filePath, err)
+		return
+	}
+	defer f.Close()
+
+	fileName := fileInfo.Name()
+	game := addGameEntry(root, strings.TrimSuffix(fileName, filepath.Ext(fileName)))
+	addRomEntry(game, fileName, fileInfo.Size(), f, filePath)
+}
+
+//addZipGame adds a zip file as a game containing each file in the zip as a rom
+func addZipGame(root *xmlquery.Node, zipFilePath string) {
+	reader, err := zip.OpenReader(zipFilePath)
+	if err != nil {
+		message(levelError, "Cannot open %s, skipping. Reason: %s", zipFilePath, err)
+		return
+	}
+	defer reader.Close()
+
+	zipFileName := filepath.Base(zipFilePath)
+	game := addGameEntry(root, strings.TrimSuffix(zipFileName, filepath.Ext(zipFileName)))
+	for _, f := range reader.File {
+		fileInfo := f.FileInfo()
+		if !fileInfo.Mode().IsRegular() || isExcludedFromDat(f.Name) {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			message(levelError, "%s could not be opened, skipping. Reason: %s", f.Name, err)
+			continue
+		}
+		addRomEntry(game, f.Name, int64(f.UncompressedSize64), r, "")
+		r.Close()
+	}
+}
+
+//addDirectoryGame adds a directory as a game containing every file below it as a rom
+func addDirectoryGame(root *xmlquery.Node, dirPath string) {
+	game := addGameEntry(root, filepath.Base(dirPath))
+	err := filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == dirPath {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || isExcludedFromDat(filePath) || isDatOutput(filePath) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			return err
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		addRomEntry(game, filepath.ToSlash(relPath), fileInfo.Size(), f, filePath)
+		return nil
+	})
+	if err != nil {
+		message(levelError, "Unable to read %s. Reason: %s", dirPath, err)
+	}
+}
+
+func (x *mkdatCommand) Execute(args []string) error {
+	dirName := mkdatCmd.Positional.Dir
+	if dirName == "" {
+		dirName = "."
+	}
+	absDir, err := filepath.Abs(dirName)
+	errorExit(err)
+
+	name := mkdatCmd.Name
+	if name == "" {
+		name = filepath.Base(absDir)
+	}
+	description := mkdatCmd.Description
+	if description == "" {
+		description = name
+	}
+	today := time.Now().Format("2006-01-02")
+	version := mkdatCmd.Version
+	if version == "" {
+		version = today
+	}
+	date := mkdatCmd.Date
+	if date == "" {
+		date = today
+	}
+
+	doc, root := newDatDocument()
+	header := addElement(root, "header")
+	addTextElement(header, "name", name)
+	addTextElement(header, "description", description)
+	addTextElement(header, "version", version)
+	addTextElement(header, "date", date)
+	if mkdatCmd.Author != "" {
+		addTextElement(header, "author", mkdatCmd.Author)
+	}
+	if mkdatCmd.Homepage != "" {
+		addTextElement(header, "homepage", mkdatCmd.Homepage)
+	}
+
+	mkdatOutput = findDatOutput()
+	filePaths := setsInDirectory(dirName)
+	sort.Strings(filePaths)
+	for _, filePath := range filePaths {
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			message(levelError, "Cannot read %s, skipping. Reason: %s", filePath, err)
+			continue
+		}
+		switch {
+		case fileInfo.IsDir():
+			addDirectoryGame(root, filePath)
+		case isExcludedFromDat(filePath) || isDatOutput(filePath):
+			continue
+		case strings.EqualFold(filepath.Ext(filePath), ".zip"):
+			addZipGame(root, filePath)
+		default:
+			addFileGame(root, filePath, fileInfo)
+		}
+	}
+	message(levelInfo, "Created dat with %d games", len(childNodeSet(root, "game")))
+
+	w := io.Writer(os.Stdout)
+	if mkdatCmd.OutputFile != "" {
+		f, err := os.Create(mkdatCmd.OutputFile)
+		if err != nil {
+			message(levelError, "%s could not be created : %s", mkdatCmd.OutputFile, err)
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return writeDatXML(w, doc)
+}
+
+func init() {
+	cmd, err := parser.AddCommand("mkdat",
+		"Create a datfile from a directory",
+		"This command will create a logiqx datfile from the loose files, zip files and directories in a directory, with one game for each",
+		&mkdatCmd)
+	errorExit(err)
+	setOptionChoices(cmd, "hash", hashMethodNames())
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type samplesCommand struct {
+	AllSets    bool   `short:"a" long:"allsets" description:"report all sample sets that are missing"`
+	OutputFile string `short:"o" long:"output" description:"file for output"`
+	SortSets   bool   `short:"s" long:"sort-sets" description:"sort sample sets alphabetically rather than by datfile order"`
+	ViewSets   string `short:"v" long:"view" description:"which items to view" choice:"all" choice:"complete" choice:"missing" choice:"partial" default:"all"`
+	Positional struct {
+		Files []string `description:"list of sample zips or directories to check against dat file (default: *)"`
+	} `positional-args:"true"`
+}
+
+var samplesCmd samplesCommand
+
+func (x *samplesCommand) slimDats() {}
+
+//sampleSetInfo holds the samples expected in a sample set, which can be shared between games
+//using the sampleof attribute. Samples are keyed by their lowercased file name, as the case of
+//the extension varies between sample sets
+type sampleSetInfo struct {
+	SetName        string
+	Found          bool
+	AllSamples     map[string]string
+	MissingSamples map[string]string
+}
+
+//findSampleSets collects the sample sets declared by the games of every dat in datfile order
+func findSampleSets() (map[string]*sampleSetInfo, []*sampleSetInfo) {
+	setMap := make(map[string]*sampleSetInfo)
+	setList := make([]*sampleSetInfo, 0)
+	for _, dat := range dats {
+		for _, game := range findGameEntries(dat.Index) {
+			samples := childNodeSet(game, "sample")
+			if len(samples) == 0 {
+				continue
+			}
+
+			setName := findAttr(game, "sampleof")
+			if setName == "" {
+				setName = findAttr(game, "name")
+			}
+			info, ok := setMap[setName]
+			if !ok {
+				info = &sampleSetInfo{setName, false, make(map[string]string), make(map[string]string)}
+				setMap[setName] = info
+				setList = append(setList, info)
+			}
+			for sample := range samples {
+				sampleName := findAttr(sample, "name") + ".wav"
+				info.AllSamples[strings.ToLower(sampleName)] = sampleName
+				info.MissingSamples[strings.ToLower(sampleName)] = sampleName
+			}
+		}
+	}
+	return setMap, setList
+}
+
+//sampleNames returns the names of the wav files in a sample zip or directory
+func sampleNames(filePath string, fileInfo os.FileInfo) []string {
+	var names []string
+	if fileInfo.IsDir() {
+		for _, samplePath := range filesInDirectory(filePath) {
+			names = append(names, filepath.Base(samplePath))
+		}
+	} else {
+		reader, err := zip.OpenReader(filePath)
+		if err != nil {
+			message(levelError, "Cannot open %s, skipping. Reason: %s", filePath, err)
+			return nil
+		}
+		defer reader.Close()
+		for _, f := range reader.File {
+			if f.FileInfo().Mode().IsRegular() {
+				names = append(names, filepath.Base(f.Name))
+			}
+		}
+	}
+
+	var samples []string
+	for _, name := range names {
+		if strings.EqualFold(filepath.Ext(name), ".wav") {
+			samples = append(samples, name)
+		} else {
+			message(levelInfo, "%s in %s is not a sample, skipping.", name, filePath)
+		}
+	}
+	return samples
+}
+
+func (x *samplesCommand) Execute(args []string) error {
+	if samplesCmd.OutputFile != "" {
+		f, err := os.Create(samplesCmd.OutputFile)
+		if err != nil {
+			message(levelError, "%s could not be created : %s", samplesCmd.OutputFile, err)
+			return err
+		}
+		outputFile = f
+	}
+
+	setMap, setList := findSampleSets()
+
+	if len(samplesCmd.Positional.Files) == 0 {
+		dirName, err := os.Getwd()
+		errorExit(err)
+		samplesCmd.Positional.Files = setsInDirectory(dirName)
+	}
+
+	output("--FILES--")
+	for _, filePath := range samplesCmd.Positional.Files {
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			message(levelError, "Cannot check %s, skipping. Reason: %s", filePath, err)
+			continue
+		}
+		if !fileInfo.IsDir() && !strings.EqualFold(filepath.Ext(filePath), ".zip") {
+			message(levelInfo, "%s is not a sample zip or directory, skipping.", filePath)
+			continue
+		}
+
+		setName := strings.TrimSuffix(fileInfo.Name(), filepath.Ext(fileInfo.Name()))
+		if fileInfo.IsDir() {
+			setName = fileInfo.Name()
+		}
+		info, ok := setMap[setName]
+		if !ok {
+			output("[MISS] %s - unknown, no sample set", fileInfo.Name())
+			continue
+		}
+
+		info.Found = true
+		for _, sampleName := range sampleNames(filePath, fileInfo) {
+			if _, ok := info.AllSamples[strings.ToLower(sampleName)]; ok {
+				delete(info.MissingSamples, strings.ToLower(sampleName))
+			} else {
+				output("[MISS] %s %s - unknown, no match", sampleName, fileInfo.Name())
+			}
+		}
+	}
+
+	output("--SETS--")
+	if samplesCmd.SortSets {
+		sort.SliceStable(setList, func(i, j int) bool { return setList[i].SetName < setList[j].SetName })
+	}
+
+	completeSets := 0
+	missingSets := 0
+	partialSets := 0
+	for _, info := range setList {
+		if !info.Found && !samplesCmd.AllSets {
+			continue
+		}
+
+		numMissing := len(info.MissingSamples)
+		if numMissing == 0 {
+			completeSets++
+			if samplesCmd.ViewSets == "all" || samplesCmd.ViewSets == "complete" {
+				output("[ OK ]  %s", info.SetName)
+			}
+		} else if len(info.AllSamples) == numMissing {
+			missingSets++
+			if samplesCmd.ViewSets == "all" || samplesCmd.ViewSets == "missing" {
+				output("[MISS]  %s", info.SetName)
+			}
+		} else {
+			partialSets++
+			if samplesCmd.ViewSets == "all" || samplesCmd.ViewSets == "partial" {
+				output("[WARN]  %s is missing:", info.SetName)
+				missing := make([]string, 0, numMissing)
+				for _, sampleName := range info.MissingSamples {
+					missing = append(missing, sampleName)
+				}
+				sort.Strings(missing)
+				for _, sampleName := range missing {
+					output("        %s", sampleName)
+				}
+			}
+		}
+	}
+	output("--SET STATISTICS--")
+	output("\tComplete: %d", completeSets)
+	output("\tPartial: %d", partialSets)
+	output("\tMissing: %d", missingSets)
+
+	return nil
+}
+
+func init() {
+	parser.AddCommand("samples",
+		"Check sample sets against datfile",
+		"This command will check sample zips against the samples declared in a datfile and determine if all samples for a set are present",
+		&samplesCmd)
+}
@@ -24,8 +24,11 @@ type zipCommand struct {
 
 var zipCmd zipCommand
 
+func (x *zipCommand) slimDats() {}
+
 func (x *zipCommand) Execute(args []string) error {
 	gameFiles := make(map[*xmlquery.Node][]string)
+	fileHashMethods := datHashMethods("sha1")
 
 	if len(zipCmd.Positional.Files) == 0 {
 		dirName, err := os.Getwd()
@@ -56,16 +59,21 @@ func (x *zipCommand) Execute(args []string) error {
 		errorExit(err)
 		defer fin.Close()
 
-		matches := matchRomEntriesBySha(datfile, shaHashFile(fin))
+		fileHashes := hashFileAll(fin, fileHashMethods)
+		var matches []*xmlquery.Node
+		for _, dat := range dats {
+			romList, matchType, _ := matchEntries(dat.Index, filepath.Base(filePath), fileHashes, "sha1")
+			if matchType == matchAll {
+				matches = append(matches, romList...)
+			}
+		}
 		message(levelDebug, "found %d matches for %s", len(matches), filePath)
 		for _, match := range matches {
-			if match.SelectAttr("name") == filepath.Base(filePath) {
-				list, ok := gameFiles[match.Parent]
-				if !ok {
-					list = make([]string, 0)
-				}
-				gameFiles[match.Parent] = append(list, filePath)
+			list, ok := gameFiles[match.Parent]
+			if !ok {
+				list = make([]string, 0)
 			}
+			gameFiles[match.Parent] = append(list, filePath)
 		}
 	}
 
@@ -5,12 +5,12 @@ go 1.20
 require (
 	github.com/antchfx/xmlquery v1.3.15
 	github.com/jessevdk/go-flags v1.5.0
+	golang.org/x/net v0.17.0
 )
 
 require (
 	github.com/antchfx/xpath v1.2.3 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
-	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 )
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//datArchiveExts are the archive types that a dat file can be read from
+var datArchiveExts = map[string]struct{}{".zip": {}, ".gz": {}, ".7z": {}}
+
+//datMemberExts are the extensions of archive members that are considered to be dat files
+var datMemberExts = map[string]struct{}{".dat": {}, ".xml": {}}
+
+//splitDatPath splits a path of the form archive.zip#member.dat into the archive path and member
+//name, unless the whole path names an existing file
+func splitDatPath(datPath string) (string, string) {
+	if _, err := os.Stat(datPath); err == nil {
+		return datPath, ""
+	}
+	i := strings.LastIndex(datPath, "#")
+	if i < 0 {
+		return datPath, ""
+	}
+	archivePath := datPath[:i]
+	if _, ok := datArchiveExts[strings.ToLower(filepath.Ext(archivePath))]; !ok {
+		return datPath, ""
+	}
+	return archivePath, datPath[i+1:]
+}
+
+//openDat opens a dat file for reading, extracting it from an archive when required
+func openDat(datPath string) (io.ReadCloser, error) {
+	archivePath, member := splitDatPath(datPath)
+	switch strings.ToLower(filepath.Ext(archivePath)) {
+	case ".zip":
+		return openZipDat(archivePath, member)
+	case ".gz":
+		if member != "" {
+			message(levelWarn, "%s only contains a single file, ignoring member %s", archivePath, member)
+		}
+		return openGzipDat(archivePath)
+	case ".7z":
+		return open7zDat(archivePath, member)
+	}
+	return os.Open(datPath)
+}
+
+//archiveReader closes the underlying archive along with the member being read
+type archiveReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *archiveReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if closeErr := r.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func openZipDat(archivePath string, member string) (io.ReadCloser, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File)
+	names := make([]string, 0, len(reader.File))
+	for _, f := range reader.File {
+		if f.FileInfo().Mode().IsRegular() {
+			files[f.Name] = f
+			names = append(names, f.Name)
+		}
+	}
+
+	name, err := chooseDatMember(archivePath, member, names)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	r, err := files[name].Open()
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	message(levelInfo, "Reading dat %s from %s", name, archivePath)
+	return &archiveReader{r, []io.Closer{reader, r}}, nil
+}
+
+func openGzipDat(archivePath string) (io.ReadCloser, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &archiveReader{r, []io.Closer{f, r}}, nil
+}
+
+//open7zDat uses the 7-zip command line tool to list and extract the dat, as there is no
+//7z support in the standard library
+func open7zDat(archivePath string, member string) (io.ReadCloser, error) {
+	listing, err := exec.Command("7z", "l", "-slt", "-ba", archivePath).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list %s with 7z: %w", archivePath, err)
+	}
+
+	var names []string
+	var path string
+	scanner := bufio.NewScanner(strings.NewReader(string(listing)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "Path = "); ok {
+			path = value
+		} else if value, ok := strings.CutPrefix(line, "Attributes = "); ok && !strings.HasPrefix(value, "D") {
+			names = append(names, path)
+		}
+	}
+
+	name, err := chooseDatMember(archivePath, member, names)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("7z", "e", "-so", archivePath, name)
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	message(levelInfo, "Reading dat %s from %s", name, archivePath)
+	return &archiveReader{r, []io.Closer{commandCloser{cmd}, r}}, nil
+}
+
+//commandCloser waits for a command to exit once its output is no longer needed
+type commandCloser struct {
+	cmd *exec.Cmd
+}
+
+func (c commandCloser) Close() error {
+	return c.cmd.Wait()
+}
+
+//chooseDatMember picks the requested member from the archive, or the only dat file
+//in the archive when no member was requested
+func chooseDatMember(archivePath string, member string, names []string) (string, error) {
+	if member != "" {
+		for _, name := range names {
+			if name == member || filepath.Base(name) == member {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("%s does not contain %s", archivePath, member)
+	}
+
+	var dats []string
+	for _, name := range names {
+		if _, ok := datMemberExts[strings.ToLower(filepath.Ext(name))]; ok {
+			dats = append(dats, name)
+		}
+	}
+	if len(dats) == 0 && len(names) == 1 {
+		dats = names
+	}
+
+	switch len(dats) {
+	case 0:
+		return "", fmt.Errorf("%s does not contain a dat file", archivePath)
+	case 1:
+		return dats[0], nil
+	}
+	return "", fmt.Errorf("%s contains multiple dat files, choose one with %s#<member>: %s",
+		archivePath, archivePath, strings.Join(dats, ", "))
+}
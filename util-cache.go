@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/antchfx/xmlquery"
+)
+
+//datCacheVersion is stored in each cache file and must be changed whenever the layout of the cache
+//or the parsed document changes, so that old cache files are rebuilt
+const datCacheVersion = 4
+
+//datCache is the compiled form of a parsed dat and its index, along with what is needed to tell if
+//it is stale. The document is stored flattened in document order with each string stored once,
+//which is much faster to decode than a nested structure, and the index refers to its nodes by
+//their position in that order
+type datCache struct {
+	Version     int
+	Path        string
+	Slim        bool
+	Size        int64
+	ModTime     int64
+	ContentHash string
+	Strings     []string
+	Types       []byte
+	Parents     []int32
+	Data        []int32
+	AttrCounts  []int32
+	Attrs       []int32
+	Games       []int32
+	GamesByName cachedAttrIndex
+	Roms        []int32
+	RomsByAttr  []cachedAttrIndex
+	Disks       []int32
+	DisksByAttr []cachedAttrIndex
+}
+
+//cachedAttrIndex is the flattened form of the entries of an attribute index for one attribute,
+//where each key is followed by the count of its nodes
+type cachedAttrIndex struct {
+	Attr   string
+	Keys   []int32
+	Counts []int32
+	Nodes  []int32
+}
+
+//setDoc flattens the document and its index into the cache, parents always come before their
+//children so the first node is the document node
+func (cache *datCache) setDoc(doc *xmlquery.Node, index *romIndex) {
+	stringIndex := make(map[string]int32)
+	intern := func(value string) int32 {
+		index, ok := stringIndex[value]
+		if !ok {
+			index = int32(len(cache.Strings))
+			stringIndex[value] = index
+			cache.Strings = append(cache.Strings, value)
+		}
+		return index
+	}
+
+	nodeIndex := make(map[*xmlquery.Node]int32)
+	var add func(node *xmlquery.Node, parent int32)
+	add = func(node *xmlquery.Node, parent int32) {
+		index := int32(len(cache.Types))
+		nodeIndex[node] = index
+		cache.Types = append(cache.Types, byte(node.Type))
+		cache.Parents = append(cache.Parents, parent)
+		cache.Data = append(cache.Data, intern(node.Data))
+		cache.AttrCounts = append(cache.AttrCounts, int32(len(node.Attr)))
+		for _, attr := range node.Attr {
+			cache.Attrs = append(cache.Attrs, intern(attr.Name.Local), intern(attr.Value))
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			add(child, index)
+		}
+	}
+	add(doc, -1)
+
+	nodes := func(list []*xmlquery.Node) []int32 {
+		indexes := make([]int32, len(list))
+		for i, node := range list {
+			indexes[i] = nodeIndex[node]
+		}
+		return indexes
+	}
+	flatten := func(attr string, byValue map[string][]*xmlquery.Node) cachedAttrIndex {
+		flat := cachedAttrIndex{Attr: attr}
+		for key, list := range byValue {
+			flat.Keys = append(flat.Keys, intern(key))
+			flat.Counts = append(flat.Counts, int32(len(list)))
+			flat.Nodes = append(flat.Nodes, nodes(list)...)
+		}
+		return flat
+	}
+	flattenAll := func(byAttr attrIndex) []cachedAttrIndex {
+		var flat []cachedAttrIndex
+		for attr, byValue := range byAttr {
+			flat = append(flat, flatten(attr, byValue))
+		}
+		return flat
+	}
+	cache.Games = nodes(index.games)
+	cache.GamesByName = flatten("name", index.gamesByName)
+	cache.Roms = nodes(index.roms)
+	cache.RomsByAttr = flattenAll(index.romsByAttr)
+	cache.Disks = nodes(index.disks)
+	cache.DisksByAttr = flattenAll(index.disksByAttr)
+}
+
+//doc rebuilds the document and its index, allocating the nodes and attributes in bulk
+func (cache *datCache) doc() (*xmlquery.Node, *romIndex) {
+	nodes := make([]xmlquery.Node, len(cache.Types))
+	attrs := make([]xmlquery.Attr, len(cache.Attrs)/2)
+	nextAttr := 0
+	for i := range nodes {
+		node := &nodes[i]
+		node.Type = xmlquery.NodeType(cache.Types[i])
+		node.Data = cache.Strings[cache.Data[i]]
+		if count := int(cache.AttrCounts[i]); count > 0 {
+			node.Attr = attrs[nextAttr : nextAttr+count : nextAttr+count]
+			for j := range node.Attr {
+				node.Attr[j].Name.Local = cache.Strings[cache.Attrs[(nextAttr+j)*2]]
+				node.Attr[j].Value = cache.Strings[cache.Attrs[(nextAttr+j)*2+1]]
+			}
+			nextAttr += count
+		}
+		if parent := cache.Parents[i]; parent >= 0 {
+			xmlquery.AddChild(&nodes[parent], node)
+		}
+	}
+
+	list := func(indexes []int32) []*xmlquery.Node {
+		result := make([]*xmlquery.Node, len(indexes))
+		for i, index := range indexes {
+			result[i] = &nodes[index]
+		}
+		return result
+	}
+	expand := func(flat cachedAttrIndex) map[string][]*xmlquery.Node {
+		byValue := make(map[string][]*xmlquery.Node, len(flat.Keys))
+		next := 0
+		for i, key := range flat.Keys {
+			count := int(flat.Counts[i])
+			byValue[cache.Strings[key]] = list(flat.Nodes[next : next+count])
+			next += count
+		}
+		return byValue
+	}
+	expandAll := func(flat []cachedAttrIndex) attrIndex {
+		byAttr := make(attrIndex, len(flat))
+		for _, attrFlat := range flat {
+			byAttr[attrFlat.Attr] = expand(attrFlat)
+		}
+		return byAttr
+	}
+	index := &romIndex{
+		games:       list(cache.Games),
+		gamesByName: expand(cache.GamesByName),
+		roms:        list(cache.Roms),
+		romsByAttr:  expandAll(cache.RomsByAttr),
+		disks:       list(cache.Disks),
+		disksByAttr: expandAll(cache.DisksByAttr),
+	}
+	return &nodes[0], index
+}
+
+//datCacheDir returns the directory holding the cache files, or an empty string if caching is off
+func datCacheDir() string {
+	if opts.NoCache {
+		return ""
+	}
+	if opts.CacheDir != "" {
+		return opts.CacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		message(levelDebug, "Unable to find user cache directory, not caching dats. Reason: %s", err)
+		return ""
+	}
+	return filepath.Join(dir, "check-roms")
+}
+
+func absDatPath(datPath string) string {
+	absPath, err := filepath.Abs(datPath)
+	if err != nil {
+		return datPath
+	}
+	return absPath
+}
+
+//datCachePath returns the cache file for a dat, named from its absolute path and whether it was
+//parsed slim as the two give different documents
+func datCachePath(cacheDir string, datPath string, slim bool) string {
+	key := absDatPath(datPath)
+	if slim {
+		key += "\x00slim"
+	}
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:])+".gob")
+}
+
+//datContentHash returns the sha1 of the file holding the dat
+func datContentHash(filePath string) string {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	return hashFile(f, "sha1")
+}
+
+//loadCachedDat returns the cached document and index for a dat, or nil if there is no cache or it
+//is stale. A cache with a different size or modification time is only used if the content is
+//unchanged, and is then written again with the new size and modification time
+func loadCachedDat(datPath string, slim bool) (*xmlquery.Node, *romIndex) {
+	cacheDir := datCacheDir()
+	if cacheDir == "" {
+		return nil, nil
+	}
+	archivePath, _ := splitDatPath(datPath)
+	fileInfo, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, nil
+	}
+
+	cachePath := datCachePath(cacheDir, datPath, slim)
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return nil, nil
+	}
+	var cache datCache
+	err = gob.NewDecoder(f).Decode(&cache)
+	f.Close()
+	if err != nil {
+		message(levelWarn, "Unable to read dat cache %s, rebuilding. Reason: %s", cachePath, err)
+		return nil, nil
+	}
+	if cache.Version != datCacheVersion || cache.Path != absDatPath(datPath) || cache.Slim != slim || len(cache.Types) == 0 {
+		return nil, nil
+	}
+	if cache.Size != fileInfo.Size() || cache.ModTime != fileInfo.ModTime().UnixNano() {
+		if cache.ContentHash != datContentHash(archivePath) {
+			message(levelInfo, "Dat cache for %s is stale, rebuilding", datPath)
+			return nil, nil
+		}
+		message(levelInfo, "Dat %s was touched but is unchanged, updating cache", datPath)
+		cache.Size = fileInfo.Size()
+		cache.ModTime = fileInfo.ModTime().UnixNano()
+		writeDatCache(cacheDir, datPath, &cache)
+	}
+	message(levelInfo, "Loaded dat %s from cache %s", datPath, cachePath)
+	doc, index := cache.doc()
+	return doc, index
+}
+
+//saveCachedDat writes the parsed document and index of a dat to the cache, replacing any earlier cache
+func saveCachedDat(datPath string, slim bool, doc *xmlquery.Node, index *romIndex) {
+	cacheDir := datCacheDir()
+	if cacheDir == "" {
+		return
+	}
+	archivePath, _ := splitDatPath(datPath)
+	fileInfo, err := os.Stat(archivePath)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		message(levelWarn, "Unable to create dat cache directory %s. Reason: %s", cacheDir, err)
+		return
+	}
+
+	cache := datCache{
+		Version:     datCacheVersion,
+		Path:        absDatPath(datPath),
+		Slim:        slim,
+		Size:        fileInfo.Size(),
+		ModTime:     fileInfo.ModTime().UnixNano(),
+		ContentHash: datContentHash(archivePath),
+	}
+	cache.setDoc(doc, index)
+	writeDatCache(cacheDir, datPath, &cache)
+}
+
+//writeDatCache writes the cache file of a dat
+func writeDatCache(cacheDir string, datPath string, cache *datCache) {
+	//write to a temporary file first so that an interrupted run never leaves a broken cache
+	f, err := os.CreateTemp(cacheDir, "dat-*.tmp")
+	if err != nil {
+		message(levelWarn, "Unable to write dat cache for %s. Reason: %s", datPath, err)
+		return
+	}
+	err = gob.NewEncoder(f).Encode(cache)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), datCachePath(cacheDir, datPath, cache.Slim))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		message(levelWarn, "Unable to write dat cache for %s. Reason: %s", datPath, err)
+		return
+	}
+	message(levelDebug, "Wrote dat cache for %s", datPath)
+}
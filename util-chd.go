@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+//chdTag is the magic at the start of every mame compressed hunks of data file
+var chdTag = []byte("MComprHD")
+
+//chdSha1Offsets gives the offset in the header of the sha1 recorded by dat files for each
+//version, which covers the raw data and, from version 4 onwards, the metadata
+var chdSha1Offsets = map[uint32]int{3: 80, 4: 48, 5: 84}
+
+//chdHeaderLengths gives the expected length of the header for each version
+var chdHeaderLengths = map[uint32]uint32{3: 120, 4: 108, 5: 124}
+
+//readChdSha1 reads the sha1 from the header of a chd file without decompressing it
+func readChdSha1(filePath string) (string, uint32, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return chdSha1(f)
+}
+
+func chdSha1(reader io.Reader) (string, uint32, error) {
+	start := make([]byte, 16)
+	if _, err := io.ReadFull(reader, start); err != nil {
+		return "", 0, fmt.Errorf("unable to read chd header: %w", err)
+	}
+	if !bytes.Equal(start[:8], chdTag) {
+		return "", 0, fmt.Errorf("not a chd file")
+	}
+
+	length := binary.BigEndian.Uint32(start[8:12])
+	version := binary.BigEndian.Uint32(start[12:16])
+	offset, ok := chdSha1Offsets[version]
+	if !ok {
+		return "", version, fmt.Errorf("unsupported chd version %d", version)
+	}
+	if length != chdHeaderLengths[version] {
+		return "", version, fmt.Errorf("invalid header length %d for chd version %d", length, version)
+	}
+
+	header := make([]byte, length)
+	copy(header, start)
+	if _, err := io.ReadFull(reader, header[len(start):]); err != nil {
+		return "", version, fmt.Errorf("unable to read chd header: %w", err)
+	}
+	return fmt.Sprintf("%x", header[offset:offset+20]), version, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/antchfx/xmlquery"
+)
+
+//cmpEntry is a single key from a clrmamepro text dat, holding either a value or a block of entries
+type cmpEntry struct {
+	Key   string
+	Value string
+	Block []cmpEntry
+	Line  int
+}
+
+type cmpToken struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+//gameAttrKeys are the clrmamepro game keys that are attributes of a logiqx game element,
+//any other simple key becomes a child element
+var gameAttrKeys = map[string]struct{}{
+	"name": {}, "cloneof": {}, "romof": {}, "sampleof": {}, "isbios": {}, "board": {}, "rebuildto": {},
+}
+
+//headerAttrKeys are the clrmamepro header keys that belong to the logiqx clrmamepro element
+var headerAttrKeys = map[string]struct{}{
+	"header": {}, "forcemerging": {}, "forcenodump": {}, "forcepacking": {},
+}
+
+//parseClrMameProDat reads a clrmamepro text dat and builds the equivalent logiqx document
+func parseClrMameProDat(reader *bufio.Reader) (*xmlquery.Node, error) {
+	entries, err := readClrMameProEntries(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, root := newDatDocument()
+	for _, entry := range entries {
+		switch entry.Key {
+		case "clrmamepro":
+			addClrMameProHeader(root, entry.Block)
+		case "game", "machine", "resource":
+			addClrMameProGame(root, entry)
+		default:
+			message(levelDebug, "Ignoring clrmamepro block %s on line %d", entry.Key, entry.Line)
+		}
+	}
+	return doc, nil
+}
+
+//readClrMameProEntries tokenizes a clrmamepro text dat and returns its top level entries
+func readClrMameProEntries(reader *bufio.Reader) ([]cmpEntry, error) {
+	tokens, err := tokenizeClrMamePro(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, next, err := parseClrMameProBlock(tokens, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if next != len(tokens) {
+		return nil, fmt.Errorf("unexpected ')' on line %d", tokens[next].line)
+	}
+	return entries, nil
+}
+
+func parseClrMameProBlock(tokens []cmpToken, pos int, nested bool) ([]cmpEntry, int, error) {
+	var entries []cmpEntry
+	for pos < len(tokens) {
+		token := tokens[pos]
+		if !token.quoted && token.text == ")" {
+			if !nested {
+				return entries, pos, nil
+			}
+			return entries, pos + 1, nil
+		}
+		if !token.quoted && token.text == "(" {
+			return nil, pos, fmt.Errorf("unexpected '(' on line %d", token.line)
+		}
+
+		entry := cmpEntry{Key: strings.ToLower(token.text), Line: token.line}
+		pos++
+		if pos >= len(tokens) {
+			return nil, pos, fmt.Errorf("missing value for %s on line %d", token.text, token.line)
+		}
+
+		value := tokens[pos]
+		switch {
+		case !value.quoted && value.text == "(":
+			block, next, err := parseClrMameProBlock(tokens, pos+1, true)
+			if err != nil {
+				return nil, next, err
+			}
+			entry.Block = block
+			pos = next
+		case !value.quoted && value.text == ")":
+			return nil, pos, fmt.Errorf("missing value for %s on line %d", token.text, token.line)
+		default:
+			entry.Value = value.text
+			pos++
+		}
+		entries = append(entries, entry)
+	}
+	if nested {
+		return nil, pos, fmt.Errorf("unexpected end of file, missing ')'")
+	}
+	return entries, pos, nil
+}
+
+func tokenizeClrMamePro(reader *bufio.Reader) ([]cmpToken, error) {
+	var tokens []cmpToken
+	var current strings.Builder
+	line := 1
+	inQuote := false
+	quoteLine := 0
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, cmpToken{current.String(), false, line})
+			current.Reset()
+		}
+	}
+
+	for {
+		r, _, err := reader.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if inQuote {
+			if r == '"' {
+				tokens = append(tokens, cmpToken{current.String(), true, quoteLine})
+				current.Reset()
+				inQuote = false
+			} else {
+				if r == '\n' {
+					line++
+				}
+				current.WriteRune(r)
+			}
+			continue
+		}
+
+		switch {
+		case r == '\n':
+			flush()
+			line++
+		case r == '\ufeff':
+			//ignore byte order mark
+		case unicode.IsSpace(r):
+			flush()
+		case r == '"':
+			flush()
+			inQuote = true
+			quoteLine = line
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, cmpToken{string(r), false, line})
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote starting on line %d", quoteLine)
+	}
+	flush()
+	return tokens, nil
+}
+
+func addClrMameProHeader(root *xmlquery.Node, block []cmpEntry) {
+	header := addElement(root, "header")
+	var settings *xmlquery.Node
+	for _, entry := range block {
+		if entry.Block != nil {
+			continue
+		}
+		if _, ok := headerAttrKeys[entry.Key]; ok {
+			if settings == nil {
+				settings = &xmlquery.Node{Type: xmlquery.ElementNode, Data: "clrmamepro"}
+			}
+			xmlquery.AddAttr(settings, entry.Key, entry.Value)
+			continue
+		}
+		addTextElement(header, entry.Key, entry.Value)
+	}
+	if settings != nil {
+		xmlquery.AddChild(header, settings)
+	}
+}
+
+func addClrMameProGame(root *xmlquery.Node, entry cmpEntry) {
+	game := addElement(root, "game")
+	if entry.Key == "resource" {
+		xmlquery.AddAttr(game, "isbios", "yes")
+	}
+	for _, child := range entry.Block {
+		switch {
+		case child.Block != nil:
+			addClrMameProItem(game, child)
+		case child.Key == "sample":
+			xmlquery.AddAttr(addElement(game, "sample"), "name", child.Value)
+		default:
+			if _, ok := gameAttrKeys[child.Key]; ok {
+				xmlquery.AddAttr(game, child.Key, child.Value)
+			} else {
+				addTextElement(game, child.Key, child.Value)
+			}
+		}
+	}
+}
+
+//addClrMameProItem converts a block inside a game, such as a rom or disk, into an element
+//with each key as an attribute
+func addClrMameProItem(game *xmlquery.Node, entry cmpEntry) {
+	item := addElement(game, entry.Key)
+	for _, child := range entry.Block {
+		if child.Block != nil {
+			continue
+		}
+		key := child.Key
+		if key == "flags" {
+			//clrmamepro uses flags for the status of the dump
+			key = "status"
+		}
+		item.SetAttr(key, child.Value)
+	}
+}
+
+//cmpQuotedKeys are the keys whose values are always quoted when writing a clrmamepro dat
+var cmpQuotedKeys = map[string]struct{}{
+	"name": {}, "description": {}, "category": {}, "version": {}, "author": {}, "homepage": {}, "url": {},
+	"comment": {}, "year": {}, "manufacturer": {}, "cloneof": {}, "romof": {}, "sampleof": {}, "merge": {},
+}
+
+//cmpValue formats a value for a clrmamepro dat, quoting it where needed
+func cmpValue(key string, value string) string {
+	_, quoted := cmpQuotedKeys[key]
+	if quoted || value == "" || strings.ContainsAny(value, " \t()") {
+		return "\"" + value + "\""
+	}
+	return value
+}
+
+//findQuotedValue returns an error for the first value of the document that contains a double quote,
+//as a clrmamepro dat has no way to escape one and the value could not be read back
+func findQuotedValue(node *xmlquery.Node) error {
+	for _, attr := range node.Attr {
+		if strings.Contains(attr.Value, "\"") {
+			return fmt.Errorf("%s %s %q contains a double quote, which clrmamepro dats cannot hold", node.Data, attr.Name.Local, attr.Value)
+		}
+	}
+	if node.Type == xmlquery.TextNode && strings.Contains(node.Data, "\"") {
+		return fmt.Errorf("%s %q contains a double quote, which clrmamepro dats cannot hold", node.Parent.Data, node.Data)
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if err := findQuotedValue(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writeClrMameProDat writes the document in clrmamepro text format, the reverse of parseClrMameProDat
+func writeClrMameProDat(w io.Writer, doc *xmlquery.Node) error {
+	if err := findQuotedValue(doc); err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(w)
+	if header := xmlquery.FindOne(doc, "/*/header"); header != nil {
+		writer.WriteString("clrmamepro (\n")
+		for child := header.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != xmlquery.ElementNode {
+				continue
+			}
+			if child.Data == "clrmamepro" {
+				for _, attr := range child.Attr {
+					fmt.Fprintf(writer, "\t%s %s\n", attr.Name.Local, cmpValue(attr.Name.Local, attr.Value))
+				}
+			} else if child.FirstChild == nil || child.FirstChild.Type == xmlquery.TextNode {
+				fmt.Fprintf(writer, "\t%s %s\n", child.Data, cmpValue(child.Data, child.InnerText()))
+			}
+		}
+		writer.WriteString(")\n")
+	}
+
+	for _, game := range setEntries(doc) {
+		blockName := "game"
+		if isBios(game) {
+			blockName = "resource"
+		}
+		fmt.Fprintf(writer, "\n%s (\n", blockName)
+		for _, attr := range game.Attr {
+			if attr.Name.Local == "isbios" {
+				continue
+			}
+			fmt.Fprintf(writer, "\t%s %s\n", attr.Name.Local, cmpValue(attr.Name.Local, attr.Value))
+		}
+		for child := game.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != xmlquery.ElementNode {
+				continue
+			}
+			switch {
+			case child.Data == "sample":
+				fmt.Fprintf(writer, "\tsample %s\n", cmpValue("name", findAttr(child, "name")))
+			case len(child.Attr) > 0:
+				fmt.Fprintf(writer, "\t%s (", child.Data)
+				for _, attr := range child.Attr {
+					key := attr.Name.Local
+					if key == "status" {
+						//clrmamepro uses flags for the status of the dump
+						key = "flags"
+					}
+					fmt.Fprintf(writer, " %s %s", key, cmpValue(key, attr.Value))
+				}
+				writer.WriteString(" )\n")
+			case child.FirstChild != nil && child.FirstChild.Type == xmlquery.TextNode:
+				fmt.Fprintf(writer, "\t%s %s\n", child.Data, cmpValue(child.Data, child.InnerText()))
+			}
+		}
+		writer.WriteString(")\n")
+	}
+	return writer.Flush()
+}
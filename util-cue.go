@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+//maxDiscSheetSize limits the size of cue and gdi files that are read as sheets, anything larger is
+//checked as an ordinary file
+const maxDiscSheetSize = 1024 * 1024
+
+//isDiscSheet returns true if the file is a cue or gdi sheet describing the tracks of a disc
+func isDiscSheet(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".cue", ".gdi":
+		return true
+	}
+	return false
+}
+
+//discSheetTracks returns the track files referenced by a cue or gdi sheet, in the order listed
+func discSheetTracks(fileName string, data []byte) []string {
+	var tracks []string
+	isGdi := strings.ToLower(filepath.Ext(fileName)) == ".gdi"
+	for i, line := range sheetLines(data) {
+		var track string
+		if isGdi {
+			//the first line of a gdi is the number of tracks, then each line is
+			//number, start sector, type, sector size, file name and offset
+			if i == 0 {
+				continue
+			}
+			track = gdiTrackFile(line)
+		} else {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || !strings.EqualFold(fields[0], "FILE") {
+				continue
+			}
+			track = quotedOrFirstField(strings.TrimSpace(line[len(fields[0]):]))
+		}
+		if track != "" && !containsString(tracks, track) {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
+}
+
+func gdiTrackFile(line string) string {
+	if start := strings.IndexByte(line, '"'); start >= 0 {
+		return quotedOrFirstField(line[start:])
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 6 {
+		return ""
+	}
+	return fields[4]
+}
+
+//quotedOrFirstField returns the quoted text at the start of the text, or its first field if it is
+//not quoted
+func quotedOrFirstField(text string) string {
+	if strings.HasPrefix(text, `"`) {
+		if end := strings.IndexByte(text[1:], '"'); end >= 0 {
+			return text[1 : end+1]
+		}
+		return text[1:]
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+//sheetLines splits a sheet into lines with any line ending, dropping trailing whitespace and blank
+//lines
+func sheetLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDiscSheetSize)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+//regeneratedSheets returns the forms a sheet could have had before it was regenerated by a tool that
+//only changed its whitespace or line endings. The lines are tried as they are and laid out the way
+//Redump writes them, with both windows and unix line endings, with and without a final line ending
+func regeneratedSheets(fileName string, data []byte) [][]byte {
+	lines := sheetLines(data)
+	layouts := [][]string{lines, redumpSheetLayout(fileName, lines)}
+
+	var sheets [][]byte
+	seen := make(map[string]struct{})
+	for _, layout := range layouts {
+		for _, ending := range []string{"\r\n", "\n"} {
+			text := strings.Join(layout, ending)
+			for _, sheet := range []string{text + ending, text} {
+				if _, ok := seen[sheet]; ok || sheet == string(data) {
+					continue
+				}
+				seen[sheet] = struct{}{}
+				sheets = append(sheets, []byte(sheet))
+			}
+		}
+	}
+	return sheets
+}
+
+//redumpSheetLayout collapses the whitespace between the fields of each line and indents cue
+//commands by their level, with tracks inside files and indexes inside tracks
+func redumpSheetLayout(fileName string, lines []string) []string {
+	isGdi := strings.ToLower(filepath.Ext(fileName)) == ".gdi"
+	layout := make([]string, 0, len(lines))
+	inTrack := false
+	for _, line := range lines {
+		line = collapseUnquotedSpace(line)
+		if isGdi {
+			layout = append(layout, line)
+			continue
+		}
+		indent := ""
+		switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+		case "FILE":
+			inTrack = false
+		case "REM", "CATALOG", "CDTEXTFILE":
+		case "TRACK":
+			inTrack = true
+			indent = "  "
+		default:
+			if inTrack {
+				indent = "    "
+			}
+		}
+		layout = append(layout, indent+line)
+	}
+	return layout
+}
+
+//collapseUnquotedSpace trims a line and replaces each run of whitespace outside quotes with a single
+//space, so that file names with several spaces are kept intact
+func collapseUnquotedSpace(line string) string {
+	var sb strings.Builder
+	quoted := false
+	space := false
+	for _, r := range strings.TrimSpace(line) {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t'):
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
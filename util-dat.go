@@ -1,53 +1,263 @@
 package main
 
 import (
-	"fmt"
+	"bufio"
+	"bytes"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/antchfx/xmlquery"
 )
 
-func parseDatFile(filePath string) *xmlquery.Node {
-	f, err := os.Open(filePath)
+//datFile is a loaded dat along with the index of its entries and the header detector it uses
+type datFile struct {
+	Path     string
+	Doc      *xmlquery.Node
+	Index    *romIndex
+	Detector *headerDetector
+	Scope    string
+}
+
+//Name returns the name from the header of the dat, or the file name if it does not have one
+func (dat *datFile) Name() string {
+	if name := xmlquery.FindOne(dat.Doc, "/*/header/name"); name != nil && name.InnerText() != "" {
+		return name.InnerText()
+	}
+	return filepath.Base(dat.Path)
+}
+
+//appliesTo returns true if files at the path should be matched against the dat, which is only
+//limited when the dat was mapped to a directory by a dat map file
+func (dat *datFile) appliesTo(filePath string) bool {
+	if dat.Scope == "" {
+		return true
+	}
+	absPath, err := filepath.Abs(filePath)
+	return err == nil && isWithinDir(absPath, dat.Scope)
+}
+
+//datFilesAtPath returns the path itself for a file, or every dat file and archive for a directory
+func datFilesAtPath(datPath string) []string {
+	info, err := os.Stat(datPath)
+	if err != nil || !info.IsDir() {
+		return []string{datPath}
+	}
+
+	var datPaths []string
+	for _, filePath := range filesInDirectory(datPath) {
+		ext := strings.ToLower(filepath.Ext(filePath))
+		_, isDat := datMemberExts[ext]
+		_, isArchive := datArchiveExts[ext]
+		if isDat || isArchive {
+			datPaths = append(datPaths, filePath)
+		}
+	}
+	if len(datPaths) == 0 {
+		message(levelWarn, "No dat files found in directory %s", datPath)
+	}
+	return datPaths
+}
+
+//datForNode returns the loaded dat that contains the node
+func datForNode(node *xmlquery.Node) *datFile {
+	for node.Parent != nil {
+		node = node.Parent
+	}
+	for _, dat := range dats {
+		if dat.Doc == node {
+			return dat
+		}
+	}
+	return nil
+}
+
+//parseDatFile parses a dat file, keeping only what is needed for matching if slim is set
+func parseDatFile(filePath string, slim bool) *xmlquery.Node {
+	f, err := openDat(filePath)
 	errorExit(err)
 	defer f.Close()
 
-	doc, err := xmlquery.Parse(f)
+	doc, err := parseDat(f, slim)
 	errorExit(err)
 
 	return doc
 }
 
-func matchRomEntriesByHexString(doc *xmlquery.Node, attribute string, hex string) []*xmlquery.Node {
-	return xmlquery.Find(doc, fmt.Sprintf("/datafile/game/rom[@%s='%s' or @%s='%s']",
-		attribute, strings.ToLower(hex), attribute, strings.ToUpper(hex)))
+type datFormat int
+
+const (
+	formatLogiqx datFormat = iota
+	formatClrMamePro
+	formatRomCenter
+)
+
+func (format datFormat) String() string {
+	switch format {
+	case formatLogiqx:
+		return "logiqx"
+	case formatClrMamePro:
+		return "clrmamepro"
+	case formatRomCenter:
+		return "romcenter"
+	}
+	return "unknown"
+}
+
+//parseDat detects the format of the dat from its content and parses it into a logiqx document
+func parseDat(r io.Reader, slim bool) (*xmlquery.Node, error) {
+	reader := bufio.NewReader(r)
+	format := detectDatFormat(reader)
+	message(levelDebug, "Detected %s dat format", format)
+	switch format {
+	case formatClrMamePro:
+		return parseClrMameProDat(reader)
+	case formatRomCenter:
+		return parseRomCenterDat(reader)
+	}
+	parse := xmlquery.Parse
+	if slim {
+		parse = parseSlimXMLDat
+	}
+	doc, err := parse(reader)
+	if err != nil {
+		return nil, err
+	}
+	normalizeSoftwareList(doc)
+	return doc, nil
+}
+
+//detectDatFormat peeks at the start of the content without consuming it, defaulting to xml
+//so that unrecognised files report xml parse errors
+func detectDatFormat(reader *bufio.Reader) datFormat {
+	start, _ := reader.Peek(512)
+	start = bytes.TrimPrefix(start, []byte("\xef\xbb\xbf"))
+	start = bytes.TrimSpace(start)
+	if len(start) == 0 || start[0] == '<' {
+		return formatLogiqx
+	}
+	if start[0] == '[' {
+		return formatRomCenter
+	}
+	words := bytes.FieldsFunc(start, func(r rune) bool {
+		return r == '(' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+	if len(words) == 0 {
+		return formatLogiqx
+	}
+	switch string(bytes.ToLower(words[0])) {
+	case "clrmamepro", "game", "machine", "resource", "emulator":
+		return formatClrMamePro
+	}
+	return formatLogiqx
+}
+
+//setEntries returns the set elements of a document in document order, which are game elements in
+//logiqx dats, machine elements in mame -listxml output and software elements in mame software
+//lists. The children of the root are walked directly as an xpath union is too slow for large dats
+func setEntries(doc *xmlquery.Node) []*xmlquery.Node {
+	var sets []*xmlquery.Node
+	for root := doc.FirstChild; root != nil; root = root.NextSibling {
+		if root.Type != xmlquery.ElementNode {
+			continue
+		}
+		for set := root.FirstChild; set != nil; set = set.NextSibling {
+			if set.Type == xmlquery.ElementNode && (set.Data == "game" || set.Data == "machine" || set.Data == "software") {
+				sets = append(sets, set)
+			}
+		}
+	}
+	return sets
+}
+
+func matchRomEntriesByHexString(index *romIndex, attribute string, hex string) []*xmlquery.Node {
+	return index.romsByValue(attribute, hex)
 }
 
-func matchRomEntriesBySha(doc *xmlquery.Node, sha string) []*xmlquery.Node {
-	return matchRomEntriesByHexString(doc, "sha1", sha)
+func matchRomEntriesByName(index *romIndex, name string) []*xmlquery.Node {
+	return index.romsByValue("name", name)
 }
 
-func matchRomEntriesByName(doc *xmlquery.Node, name string) []*xmlquery.Node {
-	//limitation with xpath is that you can delimit with ' and use "
-	//or delimit with " and use ' but you cannot escape either
-	return xmlquery.Find(doc, fmt.Sprintf("/datafile/game/rom[@name=\"%s\"]", name))
+func matchGameEntriesByName(index *romIndex, name string) []*xmlquery.Node {
+	return index.gamesByName[name]
 }
 
-func matchGameEntriesByName(doc *xmlquery.Node, name string) []*xmlquery.Node {
-	return xmlquery.Find(doc, fmt.Sprintf("/datafile/game[@name=\"%s\"]", name))
+func findRomEntriesWithName(index *romIndex, name string) []*xmlquery.Node {
+	return index.romsContaining("name", name)
 }
 
-func findRomEntriesWithName(doc *xmlquery.Node, name string) []*xmlquery.Node {
-	return xmlquery.Find(doc, fmt.Sprintf("/datafile/game/rom[contains(@name, \"%s\")]", name))
+func findGameEntriesWithName(index *romIndex, name string) []*xmlquery.Node {
+	return index.gamesContaining(name)
+}
+
+func findGameEntries(index *romIndex) []*xmlquery.Node {
+	return index.games
+}
+
+const (
+	statusBadDump = "baddump"
+	statusNoDump  = "nodump"
+)
+
+//requiredRomSet returns the roms and disks of a game that are needed for it to be complete, which
+//excludes those that have never been dumped
+func requiredRomSet(game *xmlquery.Node) NodeSet {
+	roms := childNodeSet(game, "rom")
+	for disk := range childNodeSet(game, "disk") {
+		roms[disk] = struct{}{}
+	}
+	for rom := range roms {
+		if findAttr(rom, "status") == statusNoDump {
+			delete(roms, rom)
+		}
+	}
+	return roms
+}
+
+//hasBadDumps returns true if any of the roms is a known bad dump
+func hasBadDumps(roms NodeSet) bool {
+	for rom := range roms {
+		if findAttr(rom, "status") == statusBadDump {
+			return true
+		}
+	}
+	return false
 }
 
-func findGameEntriesWithName(doc *xmlquery.Node, name string) []*xmlquery.Node {
-	return xmlquery.Find(doc, fmt.Sprintf("/datafile/game[contains(@name, \"%s\")]", name))
+//entryHashMethod returns the hash method used to match and show an entry, which is the strongest
+//hash the entry has when it does not have the requested one, as disks are only matched by sha1
+func entryHashMethod(entry *xmlquery.Node, method string) string {
+	if entry.Data == "disk" {
+		return "sha1"
+	}
+	if findAttr(entry, method) != "" {
+		return method
+	}
+	for i := len(hashMethods) - 1; i >= 0; i-- {
+		if findAttr(entry, hashMethods[i].Name) != "" {
+			return hashMethods[i].Name
+		}
+	}
+	return method
 }
 
-func findGameEntries(doc *xmlquery.Node) []*xmlquery.Node {
-	return xmlquery.Find(doc, "/datafile/game")
+//datHashMethods returns the hash methods needed to match files against the loaded dats, which is
+//the requested method along with any that entries without the requested hash are matched by
+func datHashMethods(method string) []string {
+	needed := map[string]struct{}{method: {}}
+	for _, dat := range dats {
+		for _, rom := range dat.Index.roms {
+			needed[entryHashMethod(rom, method)] = struct{}{}
+		}
+	}
+	methods := make([]string, 0, len(needed))
+	for _, hashMethod := range hashMethods {
+		if _, ok := needed[hashMethod.Name]; ok {
+			methods = append(methods, hashMethod.Name)
+		}
+	}
+	return methods
 }
 
 type match int
@@ -59,12 +269,46 @@ const (
 	matchAll
 )
 
-func matchEntries(doc *xmlquery.Node, name string, hash string, hashMethod string) ([]*xmlquery.Node, match) {
-	list := matchRomEntriesByHexString(doc, hashMethod, hash)
+//matchEntries matches a file by the hash method or, for entries that do not have that hash, by the
+//strongest hash that they do have, returning the file hash that the entries were matched by
+func matchEntries(index *romIndex, name string, hashes map[string]string, hashMethod string) ([]*xmlquery.Node, match, string) {
+	list, matchType := matchEntriesIn(index.romsByAttr, name, hashes[hashMethod], hashMethod)
+	if matchType == matchHash || matchType == matchAll {
+		return list, matchType, hashes[hashMethod]
+	}
+	for i := len(hashMethods) - 1; i >= 0; i-- {
+		fallback := hashMethods[i].Name
+		hash, ok := hashes[fallback]
+		if fallback == hashMethod || !ok {
+			continue
+		}
+		var fallbackList []*xmlquery.Node
+		for _, node := range valuesFor(index.romsByAttr, fallback, hash) {
+			if entryHashMethod(node, hashMethod) == fallback {
+				fallbackList = append(fallbackList, node)
+			}
+		}
+		if len(fallbackList) > 0 {
+			message(levelDebug, "Found %d entries without %s matching %s %s", len(fallbackList), hashMethod, fallback, hash)
+			fallbackList, fallbackType := matchHashedEntriesByName(fallbackList, name, hash)
+			return fallbackList, fallbackType, hash
+		}
+	}
+	return list, matchType, hashes[hashMethod]
+}
+
+//matchDiskEntries matches a disk image by the sha1 from its header and the name without extension
+func matchDiskEntries(index *romIndex, name string, sha1 string) ([]*xmlquery.Node, match) {
+	return matchEntriesIn(index.disksByAttr, name, sha1, "sha1")
+}
+
+func matchEntriesIn(byAttr attrIndex, name string, hash string, hashMethod string) ([]*xmlquery.Node, match) {
+	list := valuesFor(byAttr, hashMethod, hash)
 	listLength := len(list)
 	message(levelDebug, "Found %d entries matching hash %s, checking name %s...", listLength, hash, name)
 	if listLength == 0 {
-		list = matchRomEntriesByName(doc, name)
+		list = valuesFor(byAttr, "name", name)
+		listLength = len(list)
 		if listLength == 0 {
 			message(levelInfo, "Found no entries matching %s %s...", hash, name)
 			return list, matchNone
@@ -72,7 +316,12 @@ func matchEntries(doc *xmlquery.Node, name string, hash string, hashMethod strin
 		message(levelInfo, "Found %d entries matching name %s...", listLength, name)
 		return list, matchName
 	}
+	return matchHashedEntriesByName(list, name, hash)
+}
 
+//matchHashedEntriesByName checks which of the entries that matched a hash also match the name
+func matchHashedEntriesByName(list []*xmlquery.Node, name string, hash string) ([]*xmlquery.Node, match) {
+	listLength := len(list)
 	message(levelDebug, "Looking for name match %s for hash %s...", name, hash)
 	matched := make([]*xmlquery.Node, 0, listLength)
 	for _, node := range list {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	//datPointerFile holds the path of the dat to use for the directory it is in and those below it
+	datPointerFile = ".dat"
+	//datMapFile assigns dats to the directories below it, one "directory = dat" line for each
+	datMapFile = ".datmap"
+)
+
+//datRef is a dat found through a pointer or map file, along with the directory it applies to if
+//it only applies to part of the files being checked
+type datRef struct {
+	Path  string
+	Scope string
+}
+
+//datMapping is a single line of a dat map file, with both paths resolved against the map file
+type datMapping struct {
+	Dir     string
+	DatPath string
+}
+
+//findDatRefs searches the directory and its parents for the nearest dat pointer or dat map file
+func findDatRefs(dir string) ([]datRef, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for searchDir := absDir; ; searchDir = filepath.Dir(searchDir) {
+		pointerPath := filepath.Join(searchDir, datPointerFile)
+		if fileInfo, err := os.Stat(pointerPath); err == nil && fileInfo.Mode().IsRegular() {
+			datPath := readFirstLine(pointerPath)
+			if datPath == "" {
+				return nil, fmt.Errorf("%s does not name a dat file", pointerPath)
+			}
+			message(levelInfo, "Using dat pointer %s", pointerPath)
+			return []datRef{{resolveRelative(searchDir, datPath), ""}}, nil
+		}
+
+		mapPath := filepath.Join(searchDir, datMapFile)
+		if _, err := os.Stat(mapPath); err == nil {
+			mappings, err := readDatMap(mapPath)
+			if err != nil {
+				return nil, err
+			}
+			message(levelInfo, "Using dat map %s", mapPath)
+			return datRefsForDir(mappings, absDir, mapPath)
+		}
+
+		if filepath.Dir(searchDir) == searchDir {
+			return nil, nil
+		}
+	}
+}
+
+//resolveRelative resolves a path from a pointer or map file against the directory of that file
+func resolveRelative(baseDir string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, filepath.FromSlash(path))
+}
+
+func readDatMap(mapPath string) ([]datMapping, error) {
+	f, err := os.Open(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	baseDir := filepath.Dir(mapPath)
+	var mappings []datMapping
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dir, datPath, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(datPath) == "" {
+			return nil, fmt.Errorf("expected directory = dat on line %d of %s", lineNumber, mapPath)
+		}
+		mappings = append(mappings, datMapping{
+			resolveRelative(baseDir, strings.TrimSpace(dir)),
+			resolveRelative(baseDir, strings.TrimSpace(datPath)),
+		})
+	}
+	return mappings, scanner.Err()
+}
+
+//datRefsForDir chooses the mapped dats for a directory. Inside a mapped directory the dats of the
+//deepest mapping apply to everything, otherwise each mapped directory below it uses its own dats
+func datRefsForDir(mappings []datMapping, dir string, mapPath string) ([]datRef, error) {
+	deepest := ""
+	for _, mapping := range mappings {
+		if isWithinDir(dir, mapping.Dir) && len(mapping.Dir) > len(deepest) {
+			deepest = mapping.Dir
+		}
+	}
+
+	var refs []datRef
+	for _, mapping := range mappings {
+		switch {
+		case deepest != "" && mapping.Dir == deepest:
+			refs = append(refs, datRef{mapping.DatPath, ""})
+		case deepest == "" && isWithinDir(mapping.Dir, dir):
+			refs = append(refs, datRef{mapping.DatPath, mapping.Dir})
+		}
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("%s does not map any dats for %s", mapPath, dir)
+	}
+	return refs, nil
+}
+
+//isWithinDir returns true if the path is the directory or below it
+func isWithinDir(path string, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/antchfx/xmlquery"
+)
+
+//setDependencies returns the bios sets reached through romof and the device sets referenced by
+//device_ref that a game needs in order to run, ignoring devices that have no roms
+func setDependencies(index *romIndex, game *xmlquery.Node) []*xmlquery.Node {
+	var deps []*xmlquery.Node
+	visited := map[*xmlquery.Node]struct{}{game: {}}
+	for parents := parentGames(index, game); len(parents) > 0; parents = parentGames(index, parents[0]) {
+		if _, ok := visited[parents[0]]; ok {
+			break
+		}
+		visited[parents[0]] = struct{}{}
+		if isBios(parents[0]) {
+			deps = append(deps, parents[0])
+		}
+	}
+
+	for ref := game.FirstChild; ref != nil; ref = ref.NextSibling {
+		if ref.Type != xmlquery.ElementNode || ref.Data != "device_ref" {
+			continue
+		}
+		for _, device := range index.gamesByName[findAttr(ref, "name")] {
+			if _, ok := visited[device]; ok {
+				continue
+			}
+			visited[device] = struct{}{}
+			if len(requiredRomSet(device)) > 0 {
+				deps = append(deps, device)
+			}
+		}
+	}
+	return deps
+}
+
+//dependencyChecker works out which incomplete dependency sets stop a set from being playable
+type dependencyChecker struct {
+	gameMap  gameRomMap
+	blocking map[*xmlquery.Node]NodeSet
+}
+
+func newDependencyChecker(gameMap gameRomMap) *dependencyChecker {
+	return &dependencyChecker{gameMap, make(map[*xmlquery.Node]NodeSet)}
+}
+
+//isComplete returns true if the set was found with none of its roms missing
+func (checker *dependencyChecker) isComplete(game *xmlquery.Node) bool {
+	info, ok := checker.gameMap[game]
+	return ok && len(info.MissingRoms) == 0
+}
+
+//blockers returns the dependency sets of a game, direct or indirect, that are incomplete
+func (checker *dependencyChecker) blockers(index *romIndex, game *xmlquery.Node) NodeSet {
+	if blocking, ok := checker.blocking[game]; ok {
+		return blocking
+	}
+	blocking := make(NodeSet)
+	//guard against dependency cycles while this game is being resolved
+	checker.blocking[game] = blocking
+
+	for _, dep := range setDependencies(index, game) {
+		if !checker.isComplete(dep) {
+			blocking[dep] = struct{}{}
+		}
+		for other := range checker.blockers(index, dep) {
+			blocking[other] = struct{}{}
+		}
+	}
+	return blocking
+}
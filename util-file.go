@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"crypto/md5"
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
 	"log"
@@ -23,38 +25,58 @@ func renameFile(filePath string, newName string) bool {
 	return true
 }
 
-func shaHashFile(reader io.Reader) string {
-	hash := sha1.New()
-	_, err := io.Copy(hash, reader)
-	errorExit(err)
-	return fmt.Sprintf("%x", hash.Sum(nil))
+//hashMethod is a supported method of hashing a file, named as the matching rom attribute
+type hashMethod struct {
+	Name string
+	New  func() hash.Hash
 }
 
-func md5HashFile(reader io.Reader) string {
-	hash := md5.New()
-	_, err := io.Copy(hash, reader)
-	errorExit(err)
-	return fmt.Sprintf("%x", hash.Sum(nil))
+//hashMethods are the supported hash methods from weakest to strongest, add a method here to
+//make it available for matching and lookup
+var hashMethods = []hashMethod{
+	{"crc", func() hash.Hash { return crc32.NewIEEE() }},
+	{"md5", md5.New},
+	{"sha1", sha1.New},
+	{"sha256", sha256.New},
 }
 
-func crcHashFile(reader io.Reader) string {
-	hash := crc32.NewIEEE()
-	_, err := io.Copy(hash, reader)
-	errorExit(err)
-	return fmt.Sprintf("%x", hash.Sum(nil))
+//hashMethodNames returns the names of the supported hash methods
+func hashMethodNames() []string {
+	names := make([]string, 0, len(hashMethods))
+	for _, method := range hashMethods {
+		names = append(names, method.Name)
+	}
+	return names
 }
 
 func hashFile(reader io.Reader, method string) string {
-	switch method {
-	case "sha1":
-		return shaHashFile(reader)
-	case "md5":
-		return md5HashFile(reader)
-	case "crc":
-		return crcHashFile(reader)
+	return hashFileAll(reader, []string{method})[method]
+}
+
+//hashFileAll reads the file once and returns the hash for each of the methods
+func hashFileAll(reader io.Reader, methods []string) map[string]string {
+	hashes := make(map[string]hash.Hash)
+	writers := make([]io.Writer, 0, len(methods))
+	for _, method := range methods {
+		for _, hashMethod := range hashMethods {
+			if hashMethod.Name == method {
+				hashes[method] = hashMethod.New()
+				writers = append(writers, hashes[method])
+			}
+		}
+		if _, ok := hashes[method]; !ok {
+			log.Fatal("ERROR: unknown hash method")
+		}
 	}
-	log.Fatal("ERROR: unknown hash method")
-	return ""
+
+	_, err := io.Copy(io.MultiWriter(writers...), reader)
+	errorExit(err)
+
+	results := make(map[string]string)
+	for method, hash := range hashes {
+		results[method] = fmt.Sprintf("%x", hash.Sum(nil))
+	}
+	return results
 }
 
 func readFirstLine(filePath string) string {
@@ -82,6 +104,16 @@ func readFirstLine(filePath string) string {
 }
 
 func filesInDirectory(dirName string) []string {
+	return entriesInDirectory(dirName, false)
+}
+
+//setsInDirectory returns the regular files and the directories within a directory, as directories
+//can hold sets such as disk images
+func setsInDirectory(dirName string) []string {
+	return entriesInDirectory(dirName, true)
+}
+
+func entriesInDirectory(dirName string, includeDirs bool) []string {
 	dirFile, err := os.Open(dirName)
 	errorExit(err)
 
@@ -91,7 +123,7 @@ func filesInDirectory(dirName string) []string {
 	var fileNames []string
 	for _, info := range infos {
 		//ignore non-regular files
-		if !info.Mode().IsRegular() {
+		if !info.Mode().IsRegular() && !(includeDirs && info.IsDir()) {
 			continue
 		}
 
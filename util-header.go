@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+//maxHeaderedFileSize limits the size of files that are read into memory to have a header skipped,
+//headered formats are all cartridge and disk formats much smaller than this
+const maxHeaderedFileSize = 64 * 1024 * 1024
+
+//headerDetector holds the rules from a clrmamepro header detector xml file, used to find and skip
+//the copier header of a file so that it can be matched against a dat of headerless hashes
+type headerDetector struct {
+	Name  string
+	rules []headerRule
+}
+
+type headerRule struct {
+	start     string
+	end       string
+	operation string
+	tests     []headerTest
+}
+
+type headerTest struct {
+	kind     string
+	offset   string
+	value    []byte
+	mask     []byte
+	size     string
+	operator string
+	result   bool
+}
+
+//findHeaderDetector loads the header detector named in the clrmamepro header of the dat, looking
+//in the header directory if given and then alongside the dat
+func findHeaderDetector(dat *datFile, headerDir string) *headerDetector {
+	settings := xmlquery.FindOne(dat.Doc, "/*/header/clrmamepro")
+	if settings == nil {
+		return nil
+	}
+	headerName := findAttr(settings, "header")
+	if headerName == "" {
+		return nil
+	}
+
+	archivePath, _ := splitDatPath(dat.Path)
+	var searchDirs []string
+	if headerDir != "" {
+		searchDirs = append(searchDirs, headerDir)
+	}
+	searchDirs = append(searchDirs, filepath.Dir(archivePath))
+
+	for _, dir := range searchDirs {
+		detectorPath := filepath.Join(dir, headerName)
+		if _, err := os.Stat(detectorPath); err != nil {
+			continue
+		}
+		detector, err := loadHeaderDetector(detectorPath)
+		if err != nil {
+			message(levelError, "Unable to load header detector %s. Reason: %s", detectorPath, err)
+			return nil
+		}
+		message(levelInfo, "Loaded header detector %s for %s", detectorPath, dat.Name())
+		return detector
+	}
+	message(levelWarn, "Unable to find header detector %s for %s, headered files will not match", headerName, dat.Name())
+	return nil
+}
+
+func loadHeaderDetector(filePath string) (*headerDetector, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc, err := xmlquery.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	detector := &headerDetector{Name: filepath.Base(filePath)}
+	if name := xmlquery.FindOne(doc, "/detector/name"); name != nil {
+		detector.Name = name.InnerText()
+	}
+
+	for _, ruleNode := range xmlquery.Find(doc, "/detector/rule") {
+		rule := headerRule{
+			start:     findAttr(ruleNode, "start_offset"),
+			end:       findAttr(ruleNode, "end_offset"),
+			operation: findAttr(ruleNode, "operation"),
+		}
+		for testNode := ruleNode.FirstChild; testNode != nil; testNode = testNode.NextSibling {
+			if testNode.Type != xmlquery.ElementNode {
+				continue
+			}
+			test := headerTest{
+				kind:     testNode.Data,
+				offset:   findAttr(testNode, "offset"),
+				size:     findAttr(testNode, "size"),
+				operator: findAttr(testNode, "operator"),
+				result:   findAttr(testNode, "result") != "false",
+			}
+			if test.value, err = hex.DecodeString(findAttr(testNode, "value")); err != nil {
+				return nil, fmt.Errorf("invalid value in %s test: %w", test.kind, err)
+			}
+			if test.mask, err = hex.DecodeString(findAttr(testNode, "mask")); err != nil {
+				return nil, fmt.Errorf("invalid mask in %s test: %w", test.kind, err)
+			}
+			rule.tests = append(rule.tests, test)
+		}
+		detector.rules = append(detector.rules, rule)
+	}
+	return detector, nil
+}
+
+//skipHeader applies the first rule that matches the data and returns the data without its header
+func (detector *headerDetector) skipHeader(data []byte) ([]byte, bool) {
+	for _, rule := range detector.rules {
+		if !rule.matches(data) {
+			continue
+		}
+		start, okStart := headerOffset(rule.start, "0", len(data))
+		end, okEnd := headerOffset(rule.end, "EOF", len(data))
+		if !okStart || !okEnd || start > end {
+			continue
+		}
+		return applyHeaderOperation(rule.operation, data[start:end]), true
+	}
+	return nil, false
+}
+
+func (rule headerRule) matches(data []byte) bool {
+	for _, test := range rule.tests {
+		if test.matches(data) != test.result {
+			return false
+		}
+	}
+	return true
+}
+
+func (test headerTest) matches(data []byte) bool {
+	if test.kind == "file" {
+		return test.matchesSize(len(data))
+	}
+
+	offset, ok := headerOffset(test.offset, "0", len(data))
+	if !ok || offset+len(test.value) > len(data) {
+		return false
+	}
+	actual := data[offset : offset+len(test.value)]
+
+	switch test.kind {
+	case "data":
+		return bytes.Equal(actual, test.value)
+	case "or", "and", "xor":
+		if len(test.mask) != len(test.value) {
+			return false
+		}
+		for i := range actual {
+			var b byte
+			switch test.kind {
+			case "or":
+				b = actual[i] | test.mask[i]
+			case "and":
+				b = actual[i] & test.mask[i]
+			case "xor":
+				b = actual[i] ^ test.mask[i]
+			}
+			if b != test.value[i] {
+				return false
+			}
+		}
+		return true
+	}
+	message(levelWarn, "Unknown header detector test %s", test.kind)
+	return false
+}
+
+func (test headerTest) matchesSize(fileSize int) bool {
+	if strings.EqualFold(test.size, "PO2") {
+		isPowerOfTwo := fileSize > 0 && fileSize&(fileSize-1) == 0
+		return isPowerOfTwo == (test.operator == "" || test.operator == "equal")
+	}
+
+	size, err := strconv.ParseInt(test.size, 16, 64)
+	if err != nil {
+		return false
+	}
+	switch test.operator {
+	case "less":
+		return int64(fileSize) < size
+	case "greater":
+		return int64(fileSize) > size
+	}
+	return int64(fileSize) == size
+}
+
+//headerOffset parses a hex offset, where EOF is the end of the data and negative offsets count
+//back from the end of the data
+func headerOffset(text string, defaultText string, length int) (int, bool) {
+	if text == "" {
+		text = defaultText
+	}
+	if strings.EqualFold(text, "EOF") {
+		return length, true
+	}
+	offset, err := strconv.ParseInt(text, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	if offset < 0 {
+		offset += int64(length)
+	}
+	if offset < 0 || offset > int64(length) {
+		return 0, false
+	}
+	return int(offset), true
+}
+
+//applyHeaderOperation transforms the data as described by the rule, returning a copy
+func applyHeaderOperation(operation string, data []byte) []byte {
+	result := make([]byte, len(data))
+	copy(result, data)
+	switch operation {
+	case "bitswap":
+		for i := range result {
+			result[i] = bits.Reverse8(result[i])
+		}
+	case "byteswap":
+		for i := 0; i+1 < len(result); i += 2 {
+			result[i], result[i+1] = result[i+1], result[i]
+		}
+	case "wordswap":
+		for i := 0; i+3 < len(result); i += 4 {
+			result[i], result[i+1], result[i+2], result[i+3] = result[i+3], result[i+2], result[i+1], result[i]
+		}
+	case "wordbyteswap":
+		for i := 0; i+3 < len(result); i += 4 {
+			result[i], result[i+1], result[i+2], result[i+3] = result[i+2], result[i+3], result[i], result[i+1]
+		}
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+//romIndex holds lookup maps over the entries of a parsed datfile so that matching
+//a file does not require a full scan of the document
+type romIndex struct {
+	games       []*xmlquery.Node
+	gamesByName map[string][]*xmlquery.Node
+	roms        []*xmlquery.Node
+	romsByAttr  attrIndex
+	disks       []*xmlquery.Node
+	disksByAttr attrIndex
+}
+
+//attrIndex maps an attribute name and value to the entries with that value
+type attrIndex = map[string]map[string][]*xmlquery.Node
+
+//indexedAttrs are the rom attributes that are indexed for exact matching
+var indexedAttrs = append([]string{"name", "size"}, hashMethodNames()...)
+
+//indexedDiskAttrs are the disk attributes that are indexed for exact matching
+var indexedDiskAttrs = []string{"name", "md5", "sha1"}
+
+//indexDatFile walks every game and rom entry of the document once and builds the index
+func indexDatFile(doc *xmlquery.Node) *romIndex {
+	index := &romIndex{
+		gamesByName: make(map[string][]*xmlquery.Node),
+		romsByAttr:  newAttrIndex(indexedAttrs),
+		disksByAttr: newAttrIndex(indexedDiskAttrs),
+	}
+
+	for _, game := range setEntries(doc) {
+		index.addGame(game)
+	}
+	message(levelInfo, "Indexed %d games, %d roms and %d disks", len(index.games), len(index.roms), len(index.disks))
+	return index
+}
+
+func newAttrIndex(attrs []string) attrIndex {
+	byAttr := make(attrIndex)
+	for _, attr := range attrs {
+		byAttr[attr] = make(map[string][]*xmlquery.Node)
+	}
+	return byAttr
+}
+
+func (index *romIndex) addGame(game *xmlquery.Node) {
+	index.games = append(index.games, game)
+	gameName := findAttr(game, "name")
+	index.gamesByName[gameName] = append(index.gamesByName[gameName], game)
+
+	for item := game.FirstChild; item != nil; item = item.NextSibling {
+		if item.Type != xmlquery.ElementNode {
+			continue
+		}
+		switch item.Data {
+		case "rom":
+			index.roms = append(index.roms, item)
+			addToAttrIndex(index.romsByAttr, item)
+		case "disk":
+			index.disks = append(index.disks, item)
+			addToAttrIndex(index.disksByAttr, item)
+		}
+	}
+}
+
+func addToAttrIndex(byAttr attrIndex, item *xmlquery.Node) {
+	for _, attr := range item.Attr {
+		values, ok := byAttr[attr.Name.Local]
+		if !ok || attr.Value == "" {
+			continue
+		}
+		key := indexKey(attr.Name.Local, attr.Value)
+		values[key] = append(values[key], item)
+	}
+}
+
+//indexKey normalises a value so that hex strings match regardless of case
+func indexKey(attribute string, value string) string {
+	//hashes are hex strings and so are matched case-insensitively
+	if attribute != "name" && attribute != "size" {
+		return strings.ToLower(value)
+	}
+	return value
+}
+
+//romsByValue returns the rom entries whose attribute exactly matches the value
+func (index *romIndex) romsByValue(attribute string, value string) []*xmlquery.Node {
+	return valuesFor(index.romsByAttr, attribute, value)
+}
+
+//disksByValue returns the disk entries whose attribute exactly matches the value
+func (index *romIndex) disksByValue(attribute string, value string) []*xmlquery.Node {
+	return valuesFor(index.disksByAttr, attribute, value)
+}
+
+func valuesFor(byAttr attrIndex, attribute string, value string) []*xmlquery.Node {
+	values, ok := byAttr[attribute]
+	if !ok {
+		return nil
+	}
+	return values[indexKey(attribute, value)]
+}
+
+//romsContaining returns the rom entries whose attribute contains the value, in datfile order
+func (index *romIndex) romsContaining(attribute string, value string) []*xmlquery.Node {
+	var list []*xmlquery.Node
+	for _, rom := range index.roms {
+		if strings.Contains(findAttr(rom, attribute), value) {
+			list = append(list, rom)
+		}
+	}
+	return list
+}
+
+//gamesContaining returns the game entries whose name contains the value, in datfile order
+func (index *romIndex) gamesContaining(name string) []*xmlquery.Node {
+	var list []*xmlquery.Node
+	for _, game := range index.games {
+		if strings.Contains(findAttr(game, "name"), name) {
+			list = append(list, game)
+		}
+	}
+	return list
+}
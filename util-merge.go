@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/antchfx/xmlquery"
+)
+
+const (
+	setModeNonMerged = "non-merged"
+	setModeSplit     = "split"
+	setModeMerged    = "merged"
+)
+
+//parentGames returns the games that a game takes merged roms from, using romof and falling back
+//to cloneof for dats that do not include romof
+func parentGames(index *romIndex, game *xmlquery.Node) []*xmlquery.Node {
+	parentName := findAttr(game, "romof")
+	if parentName == "" {
+		parentName = findAttr(game, "cloneof")
+	}
+	if parentName == "" || parentName == findAttr(game, "name") {
+		return nil
+	}
+	return index.gamesByName[parentName]
+}
+
+//mergeSource follows the merge attribute of a rom through its parent and bios sets and returns
+//the rom entry that it is merged from, or the rom itself if it is not merged
+func mergeSource(index *romIndex, rom *xmlquery.Node) *xmlquery.Node {
+	visited := make(map[*xmlquery.Node]struct{})
+	for {
+		visited[rom] = struct{}{}
+		merge := findAttr(rom, "merge")
+		if merge == "" {
+			return rom
+		}
+
+		var source *xmlquery.Node
+		for _, parent := range parentGames(index, rom.Parent) {
+			for other := parent.FirstChild; other != nil; other = other.NextSibling {
+				if other.Type == xmlquery.ElementNode && other.Data == rom.Data && findAttr(other, "name") == merge {
+					source = other
+					break
+				}
+			}
+		}
+		if source == nil {
+			message(levelDebug, "Unable to find merged rom %s for %s in parent of %s",
+				merge, findAttr(rom, "name"), findAttr(rom.Parent, "name"))
+			return rom
+		}
+		if _, ok := visited[source]; ok {
+			return rom
+		}
+		rom = source
+	}
+}
+
+//isBios returns true if the game is marked as a bios set
+func isBios(game *xmlquery.Node) bool {
+	return findAttr(game, "isbios") == "yes"
+}
+
+//cloneRoot returns the name of the top level parent of a clone, ignoring bios sets
+func cloneRoot(index *romIndex, game *xmlquery.Node) string {
+	visited := make(map[*xmlquery.Node]struct{})
+	for {
+		visited[game] = struct{}{}
+		parents := index.gamesByName[findAttr(game, "cloneof")]
+		if len(parents) == 0 || isBios(parents[0]) {
+			return findAttr(game, "name")
+		}
+		if _, ok := visited[parents[0]]; ok {
+			return findAttr(game, "name")
+		}
+		game = parents[0]
+	}
+}
+
+//expectedContainer returns the name of the set that should contain the rom for the set mode
+func expectedContainer(index *romIndex, rom *xmlquery.Node, setMode string) string {
+	switch setMode {
+	case setModeSplit:
+		return findAttr(mergeSource(index, rom).Parent, "name")
+	case setModeMerged:
+		source := mergeSource(index, rom)
+		if isBios(source.Parent) {
+			return findAttr(source.Parent, "name")
+		}
+		return cloneRoot(index, rom.Parent)
+	}
+	return findAttr(rom.Parent, "name")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/antchfx/xmlquery"
+)
+
+//romCenterFields are the columns of a row in the games section of a romcenter dat
+const (
+	rcParentName = iota
+	rcParentDescription
+	rcGameName
+	rcGameDescription
+	rcRomName
+	rcRomCrc
+	rcRomSize
+	rcRomOf
+	rcMergeName
+	rcFieldCount
+)
+
+//parseRomCenterDat reads a romcenter dat and builds the equivalent logiqx document
+func parseRomCenterDat(reader *bufio.Reader) (*xmlquery.Node, error) {
+	doc, root := newDatDocument()
+	header := addElement(root, "header")
+	games := make(map[string]*xmlquery.Node)
+
+	section := ""
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(romCenterText(line), "\r\n")
+		if lineNumber == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			section = strings.ToUpper(strings.Trim(trimmed, "[]"))
+		case section == "GAMES":
+			if rowErr := addRomCenterRow(root, games, trimmed); rowErr != nil {
+				return nil, fmt.Errorf("%s on line %d", rowErr, lineNumber)
+			}
+		default:
+			addRomCenterHeaderValue(header, section, trimmed)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	return doc, nil
+}
+
+//romCenterText converts a line to utf-8, as romcenter dats are commonly written in latin-1
+func romCenterText(line string) string {
+	if utf8.ValidString(line) {
+		return line
+	}
+	runes := make([]rune, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		runes = append(runes, rune(line[i]))
+	}
+	return string(runes)
+}
+
+func addRomCenterHeaderValue(header *xmlquery.Node, section string, line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		message(levelDebug, "Ignoring romcenter line %s in section %s", line, section)
+		return
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	value = strings.TrimSpace(value)
+
+	switch section {
+	case "CREDITS":
+		addTextElement(header, key, value)
+	case "EMULATOR":
+		switch key {
+		case "refname":
+			addTextElement(header, "name", value)
+		case "version":
+			addTextElement(header, "description", value)
+		}
+	case "DAT":
+		settings := header.SelectElement("romcenter")
+		if settings == nil {
+			settings = addElement(header, "romcenter")
+		}
+		xmlquery.AddAttr(settings, key, value)
+	}
+}
+
+//romCenterFields splits a row of the games section into its fields, allowing the last to be left out
+func romCenterFields(line string) ([]string, error) {
+	fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(line, "¬"), "¬"), "¬")
+	if len(fields) < rcFieldCount-1 {
+		return nil, fmt.Errorf("expected %d fields, found %d", rcFieldCount, len(fields))
+	}
+	for len(fields) < rcFieldCount {
+		fields = append(fields, "")
+	}
+	return fields, nil
+}
+
+func addRomCenterRow(root *xmlquery.Node, games map[string]*xmlquery.Node, line string) error {
+	fields, err := romCenterFields(line)
+	if err != nil {
+		return err
+	}
+
+	gameName := fields[rcGameName]
+	game, ok := games[gameName]
+	if !ok {
+		game = addElement(root, "game")
+		xmlquery.AddAttr(game, "name", gameName)
+		if parent := fields[rcParentName]; parent != "" && parent != gameName {
+			xmlquery.AddAttr(game, "cloneof", parent)
+		}
+		if romOf := fields[rcRomOf]; romOf != "" && romOf != gameName {
+			xmlquery.AddAttr(game, "romof", romOf)
+		}
+		addTextElement(game, "description", fields[rcGameDescription])
+		games[gameName] = game
+	}
+
+	rom := addElement(game, "rom")
+	xmlquery.AddAttr(rom, "name", fields[rcRomName])
+	xmlquery.AddAttr(rom, "size", fields[rcRomSize])
+	xmlquery.AddAttr(rom, "crc", fields[rcRomCrc])
+	if merge := fields[rcMergeName]; merge != "" {
+		xmlquery.AddAttr(rom, "merge", merge)
+	}
+	return nil
+}
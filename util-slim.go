@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"golang.org/x/net/html/charset"
+)
+
+//slimSetElements are the children of a set that are kept by the slim loader, everything else
+//(such as the driver, input, chip and dipswitch elements of mame -listxml) is skipped unread
+var slimSetElements = map[string]struct{}{
+	"description":  {},
+	"device_ref":   {},
+	"disk":         {},
+	"manufacturer": {},
+	"part":         {},
+	"publisher":    {},
+	"release":      {},
+	"rom":          {},
+	"sample":       {},
+	"year":         {},
+}
+
+//slimSetAttrs are the attributes of a set and the elements within it that are kept by the slim
+//loader, which are those used to match files, resolve dependencies and report on sets
+var slimSetAttrs = func() map[string]struct{} {
+	attrs := map[string]struct{}{
+		"cloneof":  {},
+		"isbios":   {},
+		"isdevice": {},
+		"language": {},
+		"merge":    {},
+		"name":     {},
+		"region":   {},
+		"romof":    {},
+		"sampleof": {},
+		"size":     {},
+		"status":   {},
+	}
+	for _, method := range hashMethodNames() {
+		attrs[method] = struct{}{}
+	}
+	return attrs
+}()
+
+//parseSlimXMLDat is a reduced-memory loader for logiqx or listxml dats. It decodes the dat one token
+//at a time and builds a document holding only the header and the elements and attributes of each
+//set that are needed to match and report on files. The document is still held in memory, as the
+//matching and report code works on its nodes, but for mame -listxml it is a small part of the size
+func parseSlimXMLDat(reader io.Reader) (*xmlquery.Node, error) {
+	decoder := xml.NewDecoder(reader)
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	doc := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	parent := doc
+	depth := 0
+	inSet := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return doc, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			name := element.Name.Local
+			if depth == 2 && inSet {
+				if _, ok := slimSetElements[name]; !ok {
+					if err := decoder.Skip(); err != nil {
+						return nil, err
+					}
+					continue
+				}
+			}
+			if depth == 1 {
+				inSet = name == "game" || name == "machine" || name == "software"
+			}
+			node := addElement(parent, name)
+			for _, attr := range element.Attr {
+				if _, ok := slimSetAttrs[attr.Name.Local]; inSet && !ok {
+					continue
+				}
+				xmlquery.AddAttr(node, attr.Name.Local, attr.Value)
+			}
+			parent = node
+			depth++
+		case xml.EndElement:
+			parent = parent.Parent
+			depth--
+		case xml.CharData:
+			if depth > 1 && strings.TrimSpace(string(element)) != "" {
+				xmlquery.AddChild(parent, &xmlquery.Node{Type: xmlquery.TextNode, Data: string(element)})
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/antchfx/xmlquery"
+)
+
+//normalizeSoftwareList reshapes a mame software list, such as those in hash/*.xml, so that it can be
+//used like a logiqx dat. A header is added from the attributes of the list, and the named roms and
+//disks in the dataarea and diskarea elements of each part are moved up to be children of their
+//software element. The parts are left in place so that their interface and features can be shown
+func normalizeSoftwareList(doc *xmlquery.Node) {
+	root := xmlquery.FindOne(doc, "/softwarelist")
+	if root == nil {
+		return
+	}
+
+	if xmlquery.FindOne(root, "header") == nil {
+		header := &xmlquery.Node{Type: xmlquery.ElementNode, Data: "header"}
+		addTextElement(header, "name", findAttr(root, "name"))
+		if description := findAttr(root, "description"); description != "" {
+			addTextElement(header, "description", description)
+		}
+		prependChild(root, header)
+	}
+
+	for _, software := range setEntries(doc) {
+		var items []*xmlquery.Node
+		for part := software.FirstChild; part != nil; part = part.NextSibling {
+			if part.Type != xmlquery.ElementNode || part.Data != "part" {
+				continue
+			}
+			for area := part.FirstChild; area != nil; area = area.NextSibling {
+				if area.Type != xmlquery.ElementNode || (area.Data != "dataarea" && area.Data != "diskarea") {
+					continue
+				}
+				for item := area.FirstChild; item != nil; item = item.NextSibling {
+					//roms without names are continuations or fills of the rom before them
+					if item.Type == xmlquery.ElementNode && (item.Data == "rom" || item.Data == "disk") && findAttr(item, "name") != "" {
+						items = append(items, item)
+					}
+				}
+			}
+		}
+		for _, item := range items {
+			xmlquery.RemoveFromTree(item)
+			xmlquery.AddChild(software, item)
+		}
+	}
+}
+
+//prependChild adds the node as the first child of the parent
+func prependChild(parent *xmlquery.Node, node *xmlquery.Node) {
+	first := parent.FirstChild
+	if first == nil {
+		xmlquery.AddChild(parent, node)
+		return
+	}
+	node.Parent = parent
+	node.PrevSibling = nil
+	node.NextSibling = first
+	first.PrevSibling = node
+	parent.FirstChild = node
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+//nameTags holds the information in the tags of a No-Intro, Redump or TOSEC style set name, such as
+//"Game Title (USA, Europe) (En,Fr) (Rev 1) (Beta)" or "Game Title (1990)(Publisher)(US)(en)[b]"
+type nameTags struct {
+	Title     string
+	Regions   []string
+	Languages []string
+	Revision  string
+	Version   string
+	Flags     []string
+	DumpFlags []string
+	Other     []string
+}
+
+//tagKeys are the kinds of tag that sets can be filtered and grouped by
+var tagKeys = []string{"region", "language", "revision", "version", "flag", "dump"}
+
+//knownRegions are the region names used in set names
+var knownRegions = map[string]struct{}{
+	"Argentina": {}, "Asia": {}, "Australia": {}, "Austria": {}, "Belgium": {}, "Brazil": {},
+	"Canada": {}, "China": {}, "Croatia": {}, "Czech": {}, "Denmark": {}, "Europe": {},
+	"Finland": {}, "France": {}, "Germany": {}, "Greece": {}, "Hong Kong": {}, "India": {},
+	"Ireland": {}, "Israel": {}, "Italy": {}, "Japan": {}, "Korea": {}, "Latin America": {},
+	"Mexico": {}, "Netherlands": {}, "New Zealand": {}, "Norway": {}, "Poland": {}, "Portugal": {},
+	"Russia": {}, "Scandinavia": {}, "Singapore": {}, "South Africa": {}, "Spain": {}, "Sweden": {},
+	"Switzerland": {}, "Taiwan": {}, "Turkey": {}, "UK": {}, "United Kingdom": {}, "Unknown": {},
+	"USA": {}, "World": {},
+}
+
+//releaseRegions maps the region codes used by release elements to the names used in set names
+var releaseRegions = map[string]string{
+	"AUS": "Australia", "BRA": "Brazil", "CAN": "Canada", "CHN": "China", "EUR": "Europe",
+	"FRA": "France", "GER": "Germany", "HK": "Hong Kong", "ITA": "Italy", "JPN": "Japan",
+	"KOR": "Korea", "NED": "Netherlands", "SPA": "Spain", "SWE": "Sweden", "TAI": "Taiwan",
+	"UK": "UK", "USA": "USA", "WOR": "World",
+}
+
+//tosecRegions maps the country codes used in TOSEC names to the names used in No-Intro names
+var tosecRegions = map[string]string{
+	"AT": "Austria", "AU": "Australia", "BE": "Belgium", "BR": "Brazil", "CA": "Canada", "CN": "China",
+	"CZ": "Czech", "DE": "Germany", "DK": "Denmark", "ES": "Spain", "EU": "Europe", "FI": "Finland",
+	"FR": "France", "GB": "UK", "GR": "Greece", "HK": "Hong Kong", "IE": "Ireland", "IL": "Israel",
+	"IN": "India", "IT": "Italy", "JP": "Japan", "KR": "Korea", "MX": "Mexico", "NL": "Netherlands",
+	"NO": "Norway", "NZ": "New Zealand", "PL": "Poland", "PT": "Portugal", "RU": "Russia", "SE": "Sweden",
+	"SG": "Singapore", "TW": "Taiwan", "US": "USA", "ZA": "South Africa",
+}
+
+//flagTags are the tags that mark a set as something other than a normal release, the first word
+//of the tag is used so that tags such as "Beta 2" are recognised
+var flagTags = map[string]struct{}{
+	"Aftermarket": {}, "Alt": {}, "Beta": {}, "Debug": {}, "Demo": {}, "Hack": {}, "Kiosk": {},
+	"Pirate": {}, "Program": {}, "Promo": {}, "Proto": {}, "Prototype": {}, "Sample": {}, "Unl": {},
+}
+
+var (
+	tagPattern       = regexp.MustCompile(`\(([^()]*)\)|\[([^\[\]]*)\]`)
+	languagePattern  = regexp.MustCompile(`^[A-Z][a-z](-[A-Z][A-Za-z]+)?$`)
+	tosecLangPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]{2})*$`)
+	dumpCodePattern  = regexp.MustCompile(`^(!|[A-Za-z]+)`)
+	versionPattern   = regexp.MustCompile(`^v\d[\w.]*$`)
+	chunkPattern     = regexp.MustCompile(`\d+|\D+`)
+)
+
+//parseNameTags splits a set name into its title and tags
+func parseNameTags(name string) nameTags {
+	var tags nameTags
+	title := name
+	if loc := tagPattern.FindStringIndex(name); loc != nil {
+		title = name[:loc[0]]
+	}
+	tags.Title = strings.TrimSpace(title)
+
+	for _, match := range tagPattern.FindAllStringSubmatch(name, -1) {
+		if match[1] == "" {
+			if dumpFlag := strings.TrimSpace(match[2]); dumpFlag != "" {
+				tags.DumpFlags = append(tags.DumpFlags, dumpFlag)
+			}
+			continue
+		}
+		tag := strings.TrimSpace(match[1])
+		switch {
+		case allOf(strings.Split(tag, ","), isRegion):
+			tags.Regions = append(tags.Regions, splitTrimmed(tag)...)
+		case allOf(strings.Split(tag, ","), isLanguage):
+			tags.Languages = append(tags.Languages, splitTrimmed(tag)...)
+		case allOf(strings.Split(tag, "-"), isTosecRegion):
+			for _, code := range strings.Split(tag, "-") {
+				tags.Regions = append(tags.Regions, tosecRegions[code])
+			}
+		case tosecLangPattern.MatchString(tag):
+			for _, code := range strings.Split(tag, "-") {
+				tags.Languages = append(tags.Languages, strings.ToUpper(code[:1])+code[1:])
+			}
+		case strings.HasPrefix(tag, "Rev "):
+			tags.Revision = strings.TrimPrefix(tag, "Rev ")
+		case versionPattern.MatchString(tag):
+			tags.Version = strings.TrimPrefix(tag, "v")
+		case isFlag(tag):
+			tags.Flags = append(tags.Flags, tag)
+		default:
+			tags.Other = append(tags.Other, "("+tag+")")
+		}
+	}
+	return tags
+}
+
+//gameTags parses the tags of a set from its name, or its description if the name has no tags, and
+//adds the regions of any release elements
+func gameTags(game *xmlquery.Node) nameTags {
+	tags := parseNameTags(findAttr(game, "name"))
+	if description := xmlquery.FindOne(game, "description"); description != nil && tags.Title == findAttr(game, "name") {
+		tags = parseNameTags(description.InnerText())
+	}
+	for release := range childNodeSet(game, "release") {
+		region := findAttr(release, "region")
+		if name, ok := releaseRegions[strings.ToUpper(region)]; ok {
+			region = name
+		}
+		if region != "" && !containsString(tags.Regions, region) {
+			tags.Regions = append(tags.Regions, region)
+		}
+		if language := findAttr(release, "language"); language != "" {
+			language = strings.ToUpper(language[:1]) + strings.ToLower(language[1:])
+			if !containsString(tags.Languages, language) {
+				tags.Languages = append(tags.Languages, language)
+			}
+		}
+	}
+	return tags
+}
+
+//values returns the values of a kind of tag, using the leading code of dump flags so that "[b1]"
+//and "[b2]" are both bad dumps
+func (tags nameTags) values(key string) []string {
+	switch key {
+	case "region":
+		return tags.Regions
+	case "language":
+		return tags.Languages
+	case "revision":
+		if tags.Revision != "" {
+			return []string{tags.Revision}
+		}
+	case "version":
+		if tags.Version != "" {
+			return []string{tags.Version}
+		}
+	case "flag":
+		var flags []string
+		for _, flag := range tags.Flags {
+			flags = append(flags, strings.Fields(flag)[0])
+		}
+		return flags
+	case "dump":
+		var codes []string
+		for _, dumpFlag := range tags.DumpFlags {
+			codes = append(codes, dumpCodePattern.FindString(dumpFlag))
+		}
+		return codes
+	}
+	return nil
+}
+
+//hasFlag returns true if the set has a flag tag starting with any of the given words
+func (tags nameTags) hasFlag(words ...string) bool {
+	for _, flag := range tags.Flags {
+		first := strings.Fields(flag)[0]
+		for _, word := range words {
+			if first == word {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isRegion(text string) bool {
+	_, ok := knownRegions[strings.TrimSpace(text)]
+	return ok
+}
+
+func isTosecRegion(text string) bool {
+	_, ok := tosecRegions[text]
+	return ok
+}
+
+func isLanguage(text string) bool {
+	return languagePattern.MatchString(strings.TrimSpace(text))
+}
+
+func isFlag(tag string) bool {
+	fields := strings.Fields(tag)
+	if len(fields) == 0 {
+		return false
+	}
+	_, ok := flagTags[fields[0]]
+	return ok
+}
+
+func allOf(values []string, test func(string) bool) bool {
+	for _, value := range values {
+		if !test(value) {
+			return false
+		}
+	}
+	return len(values) > 0
+}
+
+func splitTrimmed(text string) []string {
+	var values []string
+	for _, value := range strings.Split(text, ",") {
+		values = append(values, strings.TrimSpace(value))
+	}
+	return values
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//compareVersions compares two revision or version strings, treating runs of digits as numbers so
+//that "10" sorts after "9", and returns -1, 0 or 1
+func compareVersions(a string, b string) int {
+	chunksA := chunkPattern.FindAllString(a, -1)
+	chunksB := chunkPattern.FindAllString(b, -1)
+	for i := 0; i < len(chunksA) && i < len(chunksB); i++ {
+		numA, errA := strconv.Atoi(chunksA[i])
+		numB, errB := strconv.Atoi(chunksB[i])
+		switch {
+		case errA == nil && errB == nil && numA != numB:
+			if numA < numB {
+				return -1
+			}
+			return 1
+		case (errA != nil || errB != nil) && chunksA[i] != chunksB[i]:
+			if chunksA[i] < chunksB[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(chunksA) < len(chunksB):
+		return -1
+	case len(chunksA) > len(chunksB):
+		return 1
+	}
+	return 0
+}
+
+//tagFilter selects sets by one of their name tags, written as key=value or key!=value
+type tagFilter struct {
+	Key    string
+	Value  string
+	Negate bool
+}
+
+func parseTagFilters(texts []string) ([]tagFilter, error) {
+	var filters []tagFilter
+	for _, text := range texts {
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("tag filter %q should be key=value or key!=value", text)
+		}
+		filter := tagFilter{Key: strings.ToLower(strings.TrimSpace(key)), Value: strings.TrimSpace(value)}
+		if strings.HasSuffix(filter.Key, "!") {
+			filter.Key = strings.TrimSpace(strings.TrimSuffix(filter.Key, "!"))
+			filter.Negate = true
+		}
+		if !containsString(tagKeys, filter.Key) {
+			return nil, fmt.Errorf("tag filter %q has unknown key, expected one of %s", text, strings.Join(tagKeys, ", "))
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+//matches returns true if the tags have the value, ignoring case, or do not have it when negated.
+//A value of "none" matches sets without any tag of the kind
+func (filter tagFilter) matches(tags nameTags) bool {
+	values := tags.values(filter.Key)
+	found := strings.EqualFold(filter.Value, "none") && len(values) == 0
+	for _, value := range values {
+		if strings.EqualFold(value, filter.Value) {
+			found = true
+		}
+	}
+	return found != filter.Negate
+}
+
+//matchesTagFilters returns true if the tags match every filter
+func matchesTagFilters(tags nameTags, filters []tagFilter) bool {
+	for _, filter := range filters {
+		if !filter.matches(tags) {
+			return false
+		}
+	}
+	return true
+}
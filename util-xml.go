@@ -1,6 +1,12 @@
 package main
 
 import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+
 	"github.com/antchfx/xmlquery"
 )
 
@@ -37,3 +43,94 @@ func childNodeSet(parentNode *xmlquery.Node, elementType string) NodeSet {
 	}
 	return roms
 }
+
+//nodeNames returns the sorted names of the nodes in the set
+func nodeNames(nodes NodeSet) []string {
+	names := make([]string, 0, len(nodes))
+	for node := range nodes {
+		names = append(names, findAttr(node, "name"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+//newDatDocument creates an empty document with a datafile root element, for use by
+//parsers that build the tree from formats other than logiqx xml
+func newDatDocument() (*xmlquery.Node, *xmlquery.Node) {
+	doc := &xmlquery.Node{Type: xmlquery.DocumentNode}
+	root := addElement(doc, "datafile")
+	return doc, root
+}
+
+//addElement creates a new element node and appends it to the children of the parent
+func addElement(parent *xmlquery.Node, elementType string) *xmlquery.Node {
+	node := &xmlquery.Node{Type: xmlquery.ElementNode, Data: elementType}
+	xmlquery.AddChild(parent, node)
+	return node
+}
+
+//addTextElement creates a new element node containing only the text and appends it
+//to the children of the parent
+func addTextElement(parent *xmlquery.Node, elementType string, text string) *xmlquery.Node {
+	node := addElement(parent, elementType)
+	xmlquery.AddChild(node, &xmlquery.Node{Type: xmlquery.TextNode, Data: text})
+	return node
+}
+
+//logiqxDocType is the document type declaration written at the start of logiqx dat files
+const logiqxDocType = `<!DOCTYPE datafile PUBLIC "-//Logiqx//DTD ROM Management Datafile//EN" "http://www.logiqx.com/Dats/datafile.dtd">`
+
+//writeDatXML writes the document as indented logiqx xml
+func writeDatXML(w io.Writer, doc *xmlquery.Node) error {
+	writer := bufio.NewWriter(w)
+	writer.WriteString("<?xml version=\"1.0\"?>\n")
+	for node := doc.FirstChild; node != nil; node = node.NextSibling {
+		if node.Type != xmlquery.ElementNode {
+			continue
+		}
+		if node.Data == "datafile" {
+			writer.WriteString(logiqxDocType + "\n")
+		}
+		writeElementXML(writer, node, 0)
+	}
+	return writer.Flush()
+}
+
+func writeElementXML(w *bufio.Writer, node *xmlquery.Node, indent int) {
+	w.WriteString(strings.Repeat("\t", indent))
+	w.WriteString("<" + node.Data)
+	for _, attr := range node.Attr {
+		w.WriteString(" " + attr.Name.Local + "=\"")
+		xml.EscapeText(w, []byte(attr.Value))
+		w.WriteString("\"")
+	}
+
+	hasElements := false
+	hasText := false
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case xmlquery.ElementNode:
+			hasElements = true
+		case xmlquery.TextNode, xmlquery.CharDataNode:
+			hasText = hasText || strings.TrimSpace(child.Data) != ""
+		}
+	}
+
+	switch {
+	case hasElements:
+		w.WriteString(">\n")
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == xmlquery.ElementNode {
+				writeElementXML(w, child, indent+1)
+			}
+		}
+		w.WriteString(strings.Repeat("\t", indent))
+		w.WriteString("</" + node.Data + ">\n")
+	case hasText:
+		w.WriteString(">")
+		xml.EscapeText(w, []byte(node.InnerText()))
+		w.WriteString("</" + node.Data + ">\n")
+	default:
+		w.WriteString("/>\n")
+	}
+}